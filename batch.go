@@ -0,0 +1,270 @@
+/*
+Copyright 2025 The Upbound Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/anthropics/anthropic-sdk-go/packages/jsonl"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/request"
+	"github.com/crossplane/function-sdk-go/response"
+
+	"github.com/upbound/function-claude-status-transformer/input/v1alpha1"
+	"github.com/upbound/function-claude-status-transformer/input/v1beta1"
+	canthropic "github.com/upbound/function-claude-status-transformer/internal/credentials/anthropic"
+)
+
+// Possible values of anthropic.MessageBatchResultUnion.Type.
+const (
+	batchResultSucceeded = "succeeded"
+)
+
+// A BatchClient sends messages to Claude using the Message Batches API. It's
+// satisfied by the Batches service of an anthropic.Client's Messages service.
+type BatchClient interface {
+	New(ctx context.Context, body anthropic.MessageBatchNewParams, opts ...option.RequestOption) (*anthropic.MessageBatch, error)
+	Get(ctx context.Context, messageBatchID string, opts ...option.RequestOption) (*anthropic.MessageBatch, error)
+	ResultsStreaming(ctx context.Context, messageBatchID string, opts ...option.RequestOption) *jsonl.Stream[anthropic.MessageBatchIndividualResponse]
+}
+
+// maxPendingBatchAge is how long a pending batch is tracked. Anthropic ends
+// batches within 24 hours, so an older batch is only still tracked if its XR
+// was deleted before it ended.
+const maxPendingBatchAge = 48 * time.Hour
+
+// A pendingBatch is a submitted message batch.
+type pendingBatch struct {
+	// ID of the batch.
+	ID string
+
+	// Fingerprint of the observed state the batch analyzes.
+	Fingerprint string
+
+	// Signature of the failure the batch diagnoses.
+	Signature signature
+
+	// SubmittedAt is when the batch was submitted.
+	SubmittedAt time.Time
+}
+
+// pendingBatches tracks the message batch submitted for each XR, keyed by the
+// XR's UID. Batches are tracked in memory, so a batch is forgotten if the
+// Function restarts. A new batch is submitted when that happens. Batches older
+// than maxPendingBatchAge are forgotten, so that the batches of deleted XRs
+// don't accumulate.
+type pendingBatches struct {
+	mu      sync.Mutex
+	batches map[string]pendingBatch
+	now     func() time.Time
+}
+
+func newPendingBatches() *pendingBatches {
+	return &pendingBatches{batches: make(map[string]pendingBatch), now: time.Now}
+}
+
+// Get returns the batch pending for the supplied XR UID.
+func (p *pendingBatches) Get(uid string) (pendingBatch, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.evict()
+	b, ok := p.batches[uid]
+	return b, ok
+}
+
+// Set records the batch pending for the supplied XR UID.
+func (p *pendingBatches) Set(uid string, b pendingBatch) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.evict()
+	if b.SubmittedAt.IsZero() {
+		b.SubmittedAt = p.now()
+	}
+	p.batches[uid] = b
+}
+
+// Delete forgets the batch pending for the supplied XR UID.
+func (p *pendingBatches) Delete(uid string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.batches, uid)
+}
+
+// evict forgets batches older than maxPendingBatchAge. The caller must hold
+// the lock.
+func (p *pendingBatches) evict() {
+	for uid, b := range p.batches {
+		if p.now().Sub(b.SubmittedAt) > maxPendingBatchAge {
+			delete(p.batches, uid)
+		}
+	}
+}
+
+// runBatch analyzes the composition using the Message Batches API. If no batch
+// is pending for the XR it submits one and keeps the last status. If a batch is
+// pending and has ended it turns the batch's result into the status.
+func (f *Function) runBatch(ctx context.Context, rsp *fnv1.RunFunctionResponse, in *v1beta1.StatusTransformation, out output, req *fnv1.RunFunctionRequest, fc *v1alpha1.FunctionConfig, lastStatus CompositionStatus, fp string, sig signature, params anthropic.MessageBatchNewParamsRequestParams) *fnv1.RunFunctionResponse {
+	log := f.log.WithValues("tag", req.GetMeta().GetTag())
+
+	oxr, err := request.GetObservedCompositeResource(req)
+	if err != nil {
+		response.Warning(rsp, errors.Wrap(err, "cannot get observed composite resource"))
+		out.Keep(rsp, lastStatus)
+		return rsp
+	}
+
+	// The XR's UID is used to track its batch, and to identify its request
+	// within the batch.
+	uid := string(oxr.Resource.GetUID())
+	if uid == "" {
+		response.Warning(rsp, errors.New("cannot use batch mode for a composite resource without a UID"))
+		out.Keep(rsp, lastStatus)
+		return rsp
+	}
+
+	c, err := f.newBatchClient(ctx, in, req)
+	if err != nil {
+		response.Warning(rsp, errors.Wrap(err, "cannot get LLM batch client"))
+		out.Keep(rsp, lastStatus)
+		return rsp
+	}
+
+	if pending, ok := f.batches.Get(uid); ok {
+		id := pending.ID
+		b, err := c.Get(ctx, id)
+		if err != nil {
+			response.Warning(rsp, errors.Wrapf(err, "cannot get message batch %s", id))
+			out.Keep(rsp, lastStatus)
+			return rsp
+		}
+
+		if b.ProcessingStatus != anthropic.MessageBatchProcessingStatusEnded {
+			log.Debug("Waiting for message batch to end", "id", id, "processingStatus", b.ProcessingStatus)
+			out.Keep(rsp, lastStatus)
+			return rsp
+		}
+
+		// Whatever the result, the next reconcile should submit a new
+		// batch.
+		f.batches.Delete(uid)
+
+		status, tokens, err := batchResult(ctx, c, id, uid)
+		f.recordUsage(ctx, log, fc, tokens)
+		if err != nil {
+			response.Warning(rsp, errors.Wrapf(err, "cannot get result of message batch %s", id))
+			out.Keep(rsp, lastStatus)
+			return rsp
+		}
+
+		log.Debug("Received composition status from Claude",
+			"batch", id,
+			"overallStatus", status.OverallStatus,
+			"summary", status.Summary,
+			"resourceCount", len(status.ResourceStatuses))
+
+		if err := f.cache.Add(ctx, pending.Signature, status); err != nil {
+			log.Info("Cannot cache diagnosis", "error", err)
+		}
+
+		// The status describes the observed state when the batch was
+		// submitted, which may since have changed.
+		status.Fingerprint = pending.Fingerprint
+		status.AnalyzedAt = analysisTime(in)
+		out.Set(rsp, status)
+		return rsp
+	}
+
+	if err := checkBudget(fc, f.now(), 0); err != nil {
+		response.Warning(rsp, errors.Wrap(err, "cannot submit message batch"))
+		out.Keep(rsp, lastStatus)
+		return rsp
+	}
+
+	b, err := c.New(ctx, anthropic.MessageBatchNewParams{
+		Requests: []anthropic.MessageBatchNewParamsRequest{{CustomID: uid, Params: params}},
+	})
+	if err != nil {
+		response.Warning(rsp, errors.Wrap(err, "cannot submit message batch"))
+		out.Keep(rsp, lastStatus)
+		return rsp
+	}
+
+	log.Debug("Submitted message batch", "id", b.ID)
+	f.batches.Set(uid, pendingBatch{ID: b.ID, Fingerprint: fp, Signature: sig})
+	out.Keep(rsp, lastStatus)
+	return rsp
+}
+
+// batchResult returns the status Claude submitted in response to the request
+// with the supplied custom ID, and the number of tokens Claude used to respond.
+func batchResult(ctx context.Context, c BatchClient, id, customID string) (CompositionStatus, int64, error) {
+	// The stream is nil if the request for results failed.
+	s := c.ResultsStreaming(ctx, id)
+	if s == nil {
+		return CompositionStatus{}, 0, errors.New("cannot stream results")
+	}
+	defer s.Close() //nolint:errcheck // Only fails if we can't close the response body.
+
+	for s.Next() {
+		r := s.Current()
+		if r.CustomID != customID {
+			continue
+		}
+
+		if r.Result.Type != batchResultSucceeded {
+			return CompositionStatus{}, 0, errors.Errorf("request %s: %s", r.Result.Type, r.Result.Error.Error.Message)
+		}
+
+		tokens := tokensUsed(r.Result.Message.Usage)
+
+		for _, block := range r.Result.Message.Content {
+			if b, ok := block.AsAny().(anthropic.ToolUseBlock); ok && b.Name == submitStatusToolName {
+				status, err := parseStatus(b.JSON.Input.Raw())
+				return status, tokens, errors.Wrap(err, "Claude submitted an invalid status")
+			}
+		}
+		return CompositionStatus{}, tokens, errors.Errorf("Claude's response didn't call the %s tool (stop reason %q)", submitStatusToolName, r.Result.Message.StopReason)
+	}
+
+	if err := s.Err(); err != nil {
+		return CompositionStatus{}, 0, errors.Wrap(err, "cannot read results")
+	}
+	return CompositionStatus{}, 0, errors.Errorf("no result for request %s", customID)
+}
+
+// getBatchClient returns a BatchClient configured to use Anthropic's APIs
+// directly. AWS Bedrock doesn't support the Message Batches API.
+func (f *Function) getBatchClient(_ context.Context, in *v1beta1.StatusTransformation, req *fnv1.RunFunctionRequest) (BatchClient, error) {
+	if in.UseAWS() {
+		return nil, errors.New("batch mode isn't supported when using AWS Bedrock")
+	}
+
+	a := canthropic.New(req)
+	key, err := a.GetAPIKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve Anthropic API key")
+	}
+
+	c := anthropic.NewClient(option.WithAPIKey(key))
+	return &c.Messages.Batches, nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2025 The Upbound Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/crossplane/function-sdk-go/errors"
+)
+
+// boltCacheBucket is the bbolt bucket that stores cached statuses.
+var boltCacheBucket = []byte("statuses")
+
+// A BoltCacheBackend caches statuses in a bbolt file, e.g. on a persistent
+// volume, so that they survive restarts. It evicts the statuses that expire
+// soonest when it's full.
+type BoltCacheBackend struct {
+	db   *bolt.DB
+	size int
+}
+
+// NewBoltCacheBackend returns a CacheBackend that caches up to size statuses
+// in the bbolt file at the supplied path, creating it if necessary.
+func NewBoltCacheBackend(path string, size int) (*BoltCacheBackend, error) {
+	// Only one process may open the file. Don't wait forever if another
+	// does, e.g. a Function pod that's still terminating.
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open bbolt file %s", path)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltCacheBucket)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "cannot create bbolt bucket")
+	}
+	return &BoltCacheBackend{db: db, size: size}, nil
+}
+
+// Get returns the status cached under the supplied key, or nil if no status is
+// cached.
+func (b *BoltCacheBackend) Get(_ context.Context, key string) (*CachedStatus, error) {
+	var cs *CachedStatus
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltCacheBucket).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		cs = &CachedStatus{}
+		return errors.Wrap(json.Unmarshal(v, cs), "cannot unmarshal cached status")
+	})
+	return cs, err
+}
+
+// Set caches the supplied status under the supplied key, evicting the statuses
+// that expire soonest if the cache is full.
+func (b *BoltCacheBackend) Set(_ context.Context, key string, s CachedStatus) error {
+	v, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "cannot marshal cached status")
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(boltCacheBucket)
+		if err := bkt.Put([]byte(key), v); err != nil {
+			return errors.Wrap(err, "cannot put cached status")
+		}
+
+		statuses := map[string]CachedStatus{}
+		if err := bkt.ForEach(func(k, v []byte) error {
+			cs := CachedStatus{}
+			// Evict statuses we can't read before any others.
+			_ = json.Unmarshal(v, &cs)
+			statuses[string(k)] = cs
+			return nil
+		}); err != nil {
+			return errors.Wrap(err, "cannot list cached statuses")
+		}
+
+		// Keys can't be deleted while iterating over the bucket.
+		for _, k := range soonestToExpire(statuses, b.size) {
+			if err := bkt.Delete([]byte(k)); err != nil {
+				return errors.Wrap(err, "cannot evict cached status")
+			}
+		}
+		return nil
+	})
+}
+
+// Close the bbolt file.
+func (b *BoltCacheBackend) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2025 The Upbound Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+)
+
+// annotationLastAppliedConfiguration is set by kubectl apply. It duplicates
+// the resource's spec.
+const annotationLastAppliedConfiguration = "kubectl.kubernetes.io/last-applied-configuration"
+
+// A trimLevel determines how aggressively the resources sent to Claude are
+// trimmed to fit the prompt within its budget. Each level trims everything
+// the previous level did.
+type trimLevel int
+
+const (
+	// trimNone sends the resources as observed.
+	trimNone trimLevel = iota
+
+	// trimNoise drops fields that rarely help diagnose a resource, like
+	// metadata.managedFields.
+	trimNoise
+
+	// trimHealthy omits healthy composed resources. Claude can still fetch
+	// them using the get_resource tool.
+	trimHealthy
+
+	// trimComposite reduces the composite resource to its identity and
+	// conditions.
+	trimComposite
+)
+
+// estimateTokens estimates the number of tokens Claude will use to encode the
+// supplied text. Claude uses roughly one token per four characters of English
+// or JSON.
+func estimateTokens(text ...string) int64 {
+	n := 0
+	for _, t := range text {
+		n += len(t)
+	}
+	return int64((n + 3) / 4)
+}
+
+// promptResources are the resources sent to Claude.
+type promptResources struct {
+	// composite is the observed composite resource, including any
+	// connection details, encoded as JSON.
+	composite string
+
+	// compositeResource is the manifest of the observed composite resource,
+	// encoded as JSON.
+	compositeResource string
+
+	observed observedResources
+
+	// manifests is true if the full manifest of each composed resource
+	// should be sent, not only a summary.
+	manifests bool
+}
+
+// Trim returns the composite resource, the composed resources, and the
+// description of the composed resources to send to Claude, trimmed according
+// to the supplied level.
+func (r promptResources) Trim(level trimLevel) (composite string, observed observedResources, composed string) {
+	composite, observed = r.composite, r.observed
+	if level >= trimNoise {
+		composite = withoutNoise(r.compositeResource)
+		observed = make(observedResources, len(r.observed))
+		for name, j := range r.observed {
+			observed[name] = withoutNoise(j)
+		}
+	}
+	if level >= trimComposite {
+		composite = compositeIdentity(composite)
+	}
+
+	described := observed
+	if level >= trimHealthy {
+		described = observed.unhealthy()
+	}
+
+	if len(described) == 0 && len(observed) > 0 {
+		composed = "All composed resources are healthy.\n"
+	} else {
+		composed = described.Summary()
+	}
+	if r.manifests && len(described) > 0 {
+		composed += "\n" + described.Manifests()
+	}
+	if omitted := len(observed) - len(described); omitted > 0 {
+		composed += fmt.Sprintf("\nOmitted %d healthy composed resources to save space.\n", omitted)
+	}
+
+	return composite, observed, composed
+}
+
+// unhealthy returns the resources that aren't healthy.
+func (r observedResources) unhealthy() observedResources {
+	out := make(observedResources)
+	for name, j := range r {
+		if healthy(j) {
+			continue
+		}
+		out[name] = j
+	}
+	return out
+}
+
+// withoutNoise returns the supplied JSON manifest without fields that rarely
+// help diagnose a resource. It returns the manifest unchanged if it isn't a
+// JSON object.
+func withoutNoise(manifest string) string {
+	m := map[string]any{}
+	if err := json.Unmarshal([]byte(manifest), &m); err != nil {
+		return manifest
+	}
+
+	if md, ok := m["metadata"].(map[string]any); ok {
+		delete(md, "managedFields")
+		if a, ok := md["annotations"].(map[string]any); ok {
+			delete(a, annotationLastAppliedConfiguration)
+			if len(a) == 0 {
+				delete(md, "annotations")
+			}
+		}
+	}
+
+	// Marshalling a map we just unmarshalled can't fail.
+	out, _ := json.Marshal(m)
+	return string(out)
+}
+
+// compositeIdentity returns the identity and conditions of the supplied
+// composite resource, encoded as JSON and keyed by path.
+func compositeIdentity(manifest string) string {
+	id := map[string]json.RawMessage{}
+	for _, p := range []string{"apiVersion", "kind", "metadata.name", "metadata.namespace", "metadata.uid", "metadata.generation", "status.conditions"} {
+		if v := gjson.Get(manifest, p); v.Exists() {
+			id[p] = json.RawMessage(v.Raw)
+		}
+	}
+	// Marshalling a map of valid raw JSON can't fail.
+	out, _ := json.Marshal(id)
+	return string(out)
+}
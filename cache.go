@@ -0,0 +1,365 @@
+/*
+Copyright 2025 The Upbound Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+var (
+	// volatileTimestamp matches the RFC 3339 timestamps Kubernetes uses,
+	// e.g. in creationTimestamp and lastTransitionTime.
+	volatileTimestamp = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})`)
+
+	// volatileCounter matches counters that differ between otherwise
+	// identical resources.
+	volatileCounter = regexp.MustCompile(`"(resourceVersion|generation|observedGeneration)":\s*"?\d+"?`)
+)
+
+// cacheFormatVersion is the version of the format of cached statuses. Bump it
+// to invalidate statuses cached by older versions of the Function.
+const cacheFormatVersion = 2
+
+// A signature identifies a failure. Compositions that fail for the same
+// reason, e.g. because they use the same broken ProviderConfig, have the same
+// signature.
+type signature struct {
+	// key is a hash of the normalized prompt variables.
+	key string
+
+	// version is a hash of the model and the rest of the prompt. A status
+	// cached by a different model or prompt isn't reused.
+	version string
+
+	// mask replaces the names and UIDs of the resources with placeholders,
+	// and unmask replaces the placeholders with the names and UIDs. They're
+	// nil if identities aren't masked.
+	mask   replacer
+	unmask replacer
+}
+
+// A replacer replaces text.
+type replacer interface {
+	Replace(s string) string
+}
+
+// A CachedStatus is a status cached for reuse.
+type CachedStatus struct {
+	// Version of the model and prompt that produced the status.
+	Version string `json:"version"`
+
+	// Expires is when the status may no longer be reused.
+	Expires time.Time `json:"expires"`
+
+	// Status Claude produced, with identities masked.
+	Status CompositionStatus `json:"status"`
+}
+
+// A CacheBackend stores cached statuses.
+type CacheBackend interface {
+	// Get returns the status cached under the supplied key, or nil if no
+	// status is cached.
+	Get(ctx context.Context, key string) (*CachedStatus, error)
+
+	// Set caches the supplied status under the supplied key.
+	Set(ctx context.Context, key string, s CachedStatus) error
+}
+
+// A statusCache caches the statuses Claude produces, keyed by the signature of
+// the failure they diagnose. A statusCache without a backend caches nothing.
+type statusCache struct {
+	backend CacheBackend
+	ttl     time.Duration
+
+	// maskIdentities masks the names and UIDs of resources, so that
+	// compositions whose resources fail for the same reason share a
+	// signature.
+	maskIdentities bool
+
+	now func() time.Time
+}
+
+func newStatusCache(b CacheBackend, ttl time.Duration, maskIdentities bool) *statusCache {
+	return &statusCache{backend: b, ttl: ttl, maskIdentities: maskIdentities, now: time.Now}
+}
+
+// Signature returns the signature of the supplied request, given the
+// variables, model, and rest of the prompt sent to Claude. The last status is
+// ignored, since it's specific to one composite resource.
+func (c *statusCache) Signature(req *fnv1.RunFunctionRequest, v *Variables, model string, prompt ...string) (signature, error) {
+	sig := signature{}
+	if c.maskIdentities {
+		observed, err := newObservedResources(req.GetObserved().GetResources())
+		if err != nil {
+			return sig, errors.Wrap(err, "cannot marshal observed composed resources to JSON")
+		}
+		xr, err := marshaler.Marshal(req.GetObserved().GetComposite().GetResource())
+		if err != nil {
+			return sig, errors.Wrap(err, "cannot marshal observed composite resource to JSON")
+		}
+		sig.mask, sig.unmask = identityReplacers(string(xr), observed)
+	}
+
+	sig.key = sig.hash(v.Composite, v.Composed, v.Dependencies, v.Input)
+	sig.version = sig.hash(append([]string{strconv.Itoa(cacheFormatVersion), model}, prompt...)...)
+	return sig, nil
+}
+
+// hash returns a hash of the supplied text, normalized by masking identities
+// and values that differ between otherwise identical resources.
+func (sig signature) hash(text ...string) string {
+	h := sha256.New()
+	for _, s := range text {
+		if sig.mask != nil {
+			s = sig.mask.Replace(s)
+		}
+		s = volatileTimestamp.ReplaceAllString(s, "<time>")
+		s = volatileCounter.ReplaceAllString(s, `"$1":"<n>"`)
+
+		// Write the length first, so that moving text from one
+		// string to the next changes the hash.
+		fmt.Fprintf(h, "%d:%s", len(s), s)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get returns the status cached for the supplied signature, if any.
+func (c *statusCache) Get(ctx context.Context, sig signature) (CompositionStatus, bool, error) {
+	if c.backend == nil {
+		return CompositionStatus{}, false, nil
+	}
+
+	cs, err := c.backend.Get(ctx, sig.key)
+	if err != nil {
+		return CompositionStatus{}, false, errors.Wrap(err, "cannot get cached status")
+	}
+	if cs == nil || cs.Version != sig.version || c.now().After(cs.Expires) {
+		return CompositionStatus{}, false, nil
+	}
+	return replaceStatus(cs.Status, sig.unmask), true, nil
+}
+
+// Add caches the supplied status under the supplied signature.
+func (c *statusCache) Add(ctx context.Context, sig signature, status CompositionStatus) error {
+	if c.backend == nil {
+		return nil
+	}
+
+	// The fingerprint and analysis time are specific to one composite
+	// resource.
+	status.Fingerprint = ""
+	status.AnalyzedAt = time.Time{}
+
+	cs := CachedStatus{Version: sig.version, Expires: c.now().Add(c.ttl), Status: replaceStatus(status, sig.mask)}
+	return errors.Wrap(c.backend.Set(ctx, sig.key, cs), "cannot cache status")
+}
+
+// A MemoryCacheBackend caches statuses in memory. It evicts the least recently
+// used status when it's full.
+type MemoryCacheBackend struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+
+	// order of use. The front is the most recently used entry.
+	order *list.List
+}
+
+// A memoryCacheEntry is a status cached in memory.
+type memoryCacheEntry struct {
+	key    string
+	status CachedStatus
+}
+
+// NewMemoryCacheBackend returns a CacheBackend that caches up to size
+// statuses in memory.
+func NewMemoryCacheBackend(size int) *MemoryCacheBackend {
+	return &MemoryCacheBackend{size: size, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// Get returns the status cached under the supplied key, or nil if no status is
+// cached.
+func (m *MemoryCacheBackend) Get(_ context.Context, key string) (*CachedStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	m.order.MoveToFront(e)
+	cs := e.Value.(*memoryCacheEntry).status //nolint:forcetypeassert // We only store *memoryCacheEntry.
+	return &cs, nil
+}
+
+// Set caches the supplied status under the supplied key, evicting the least
+// recently used status if the cache is full.
+func (m *MemoryCacheBackend) Set(_ context.Context, key string, s CachedStatus) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[key]; ok {
+		e.Value = &memoryCacheEntry{key: key, status: s}
+		m.order.MoveToFront(e)
+		return nil
+	}
+	m.entries[key] = m.order.PushFront(&memoryCacheEntry{key: key, status: s})
+
+	for m.order.Len() > m.size {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryCacheEntry).key) //nolint:forcetypeassert // We only store *memoryCacheEntry.
+	}
+	return nil
+}
+
+// soonestToExpire returns the keys of the statuses that expire soonest, such
+// that removing them leaves at most size statuses.
+func soonestToExpire(statuses map[string]CachedStatus, size int) []string {
+	if len(statuses) <= size {
+		return nil
+	}
+	keys := make([]string, 0, len(statuses))
+	for k := range statuses {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return statuses[keys[i]].Expires.Before(statuses[keys[j]].Expires) })
+	return keys[:len(statuses)-size]
+}
+
+// identityReplacers returns replacers that mask and unmask the names and UIDs
+// of the supplied composite and composed resources. Composed resources are
+// identified by their composition resource name, which is the same for every
+// composite resource that uses a composition. Only whole tokens are masked.
+func identityReplacers(xr string, observed observedResources) (mask, unmask replacer) {
+	type identity struct{ value, placeholder string }
+	ids := []identity{
+		{gjson.Get(xr, "metadata.name").String(), "<composite:name>"},
+		{gjson.Get(xr, "metadata.uid").String(), "<composite:uid>"},
+	}
+	for _, name := range observed.names() {
+		j := observed[name]
+		ids = append(ids,
+			identity{gjson.Get(j, "metadata.name").String(), fmt.Sprintf("<resource:%s:name>", name)},
+			identity{gjson.Get(j, "metadata.uid").String(), fmt.Sprintf("<resource:%s:uid>", name)},
+		)
+	}
+
+	// Both replacers prefer the first of several matching strings, so
+	// replace longer identities first. Composed resource names often
+	// start with the composite resource's name.
+	sort.SliceStable(ids, func(i, j int) bool { return len(ids[i].value) > len(ids[j].value) })
+
+	m := &tokenReplacer{}
+	u := make([]string, 0, len(ids)*2)
+	for _, id := range ids {
+		if id.value == "" {
+			continue
+		}
+		m.old = append(m.old, id.value)
+		m.new = append(m.new, id.placeholder)
+		u = append(u, id.placeholder, id.value)
+	}
+	return m, strings.NewReplacer(u...)
+}
+
+// A tokenReplacer replaces whole tokens, so that a short name like "db" isn't
+// replaced within a longer word like "feedback". A token is delimited by
+// anything that can't appear in a name or UID, like whitespace, quotes, or
+// punctuation.
+type tokenReplacer struct {
+	// old strings and the new strings that replace them, in order of
+	// preference.
+	old, new []string
+}
+
+// Replace returns a copy of s with every whole token that matches an old string
+// replaced by the corresponding new string.
+func (r *tokenReplacer) Replace(s string) string {
+	b := &strings.Builder{}
+	for i := 0; i < len(s); {
+		if n, ok := r.match(s, i); ok {
+			b.WriteString(r.new[n])
+			i += len(r.old[n])
+			continue
+		}
+		b.WriteByte(s[i])
+		i++
+	}
+	return b.String()
+}
+
+// match returns the index of the first old string that is a whole token
+// starting at s[i].
+func (r *tokenReplacer) match(s string, i int) (int, bool) {
+	if i > 0 && isTokenByte(s[i-1]) {
+		return 0, false
+	}
+	for n, old := range r.old {
+		end := i + len(old)
+		if !strings.HasPrefix(s[i:], old) || (end < len(s) && isTokenByte(s[end])) {
+			continue
+		}
+		return n, true
+	}
+	return 0, false
+}
+
+// isTokenByte returns true if the supplied byte may appear within a name or
+// UID token.
+func isTokenByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_'
+}
+
+// replaceStatus returns a copy of the supplied status with the supplied
+// replacer applied to its free text. It returns the status unchanged if the
+// replacer is nil.
+func replaceStatus(status CompositionStatus, r replacer) CompositionStatus {
+	if r == nil {
+		return status
+	}
+	out := CompositionStatus{
+		ResourceStatuses: make([]composedResourceStatus, len(status.ResourceStatuses)),
+		OverallStatus:    status.OverallStatus,
+		Summary:          r.Replace(status.Summary),
+		Category:         status.Category,
+	}
+	for i, s := range status.ResourceStatuses {
+		s.Name = r.Replace(s.Name)
+		s.Message = r.Replace(s.Message)
+		s.SuggestedFix = r.Replace(s.SuggestedFix)
+		s.CausedBy = r.Replace(s.CausedBy)
+		out.ResourceStatuses[i] = s
+	}
+	return out
+}
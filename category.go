@@ -0,0 +1,139 @@
+/*
+Copyright 2025 The Upbound Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+// Categories of failure. Unlike the messages Claude writes, they're stable
+// enough for alerting to key on.
+const (
+	// categoryAuthentication failures are caused by missing, invalid, or
+	// insufficiently privileged credentials.
+	categoryAuthentication = "Authentication"
+
+	// categoryQuota failures are caused by an exceeded quota or rate limit.
+	categoryQuota = "Quota"
+
+	// categoryInvalidConfiguration failures are caused by an invalid spec
+	// that must be fixed.
+	categoryInvalidConfiguration = "InvalidConfiguration"
+
+	// categoryDependencyNotReady failures are caused by a resource waiting
+	// for another resource that isn't ready yet.
+	categoryDependencyNotReady = "DependencyNotReady"
+
+	// categoryTransient failures are expected to resolve themselves, e.g.
+	// a resource that's still being created.
+	categoryTransient = "Transient"
+
+	// categoryProviderError failures are caused by an error returned by the
+	// provider or the external system it manages.
+	categoryProviderError = "ProviderError"
+
+	// categoryUnknown failures fit no other category.
+	categoryUnknown = "Unknown"
+)
+
+// categories are the possible categories of failure.
+var categories = []string{
+	categoryAuthentication,
+	categoryQuota,
+	categoryInvalidConfiguration,
+	categoryDependencyNotReady,
+	categoryTransient,
+	categoryProviderError,
+	categoryUnknown,
+}
+
+// Short reasons of the HealthyAccordingToClaude condition in Status output
+// mode.
+const (
+	reasonHealthy   = "Healthy"
+	reasonUnhealthy = "Unhealthy"
+)
+
+// categoryReasons are the short condition reasons of each category.
+var categoryReasons = map[string]string{
+	categoryAuthentication:       "AuthenticationFailed",
+	categoryQuota:                "QuotaExceeded",
+	categoryInvalidConfiguration: "InvalidConfiguration",
+	categoryDependencyNotReady:   "DependencyNotReady",
+	categoryTransient:            "TransientFailure",
+	categoryProviderError:        "ProviderError",
+	categoryUnknown:              reasonUnhealthy,
+}
+
+// validCategory returns true if the supplied category is known. An empty
+// category is valid, and treated as unknown.
+func validCategory(c string) bool {
+	if c == "" {
+		return true
+	}
+	_, ok := categoryReasons[c]
+	return ok
+}
+
+// category returns the category of the composition's failure, or an empty
+// string if the composition is ready. A status without a category, e.g. one
+// read from the condition reason, has the category of its first categorized
+// root cause, or else of its first categorized resource status.
+func (s CompositionStatus) category() string {
+	if s.OverallStatus == overallStatusReady {
+		return ""
+	}
+	if s.Category != "" {
+		return s.Category
+	}
+	for _, rs := range s.ResourceStatuses {
+		if rs.RootCause && rs.Category != "" {
+			return rs.Category
+		}
+	}
+	for _, rs := range s.ResourceStatuses {
+		if rs.Category != "" {
+			return rs.Category
+		}
+	}
+	return categoryUnknown
+}
+
+// reason returns the short CamelCase reason of the condition that represents
+// the status.
+func (s CompositionStatus) reason() string {
+	c := s.category()
+	if c == "" {
+		return reasonHealthy
+	}
+	if r, ok := categoryReasons[c]; ok {
+		return r
+	}
+	return reasonUnhealthy
+}
+
+// severity returns the severity of the result that represents the status.
+// Failures that need someone to act are warnings. Failures that may resolve
+// themselves, or that can't be categorized, aren't.
+func (s CompositionStatus) severity() fnv1.Severity {
+	switch s.category() {
+	case categoryAuthentication, categoryQuota, categoryInvalidConfiguration, categoryProviderError:
+		return fnv1.Severity_SEVERITY_WARNING
+	default:
+		return fnv1.Severity_SEVERITY_NORMAL
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2025 The Upbound Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/crossplane/function-sdk-go/errors"
+)
+
+// configMapCacheRefresh is how long the statuses read from a ConfigMap are
+// reused before it's read again.
+const configMapCacheRefresh = time.Minute
+
+// A ConfigMapCacheBackend caches statuses in a ConfigMap, so that they survive
+// restarts and are shared by every replica of the Function. It evicts the
+// statuses that expire soonest when it's full. A ConfigMap may store at most
+// 1MiB of data, which limits how many statuses it can cache.
+type ConfigMapCacheBackend struct {
+	// The Function only caches ConfigMaps that supply examples, so the
+	// cache ConfigMap is read directly from the API server. Its data is
+	// kept in memory between reads.
+	reader client.Reader
+	writer client.Writer
+
+	ref  types.NamespacedName
+	size int
+
+	refresh time.Duration
+	now     func() time.Time
+
+	mu     sync.Mutex
+	data   map[string]string
+	readAt time.Time
+}
+
+// NewConfigMapCacheBackend returns a CacheBackend that caches up to size
+// statuses in the referenced ConfigMap, creating it if necessary.
+func NewConfigMapCacheBackend(r client.Reader, w client.Writer, ref types.NamespacedName, size int) *ConfigMapCacheBackend {
+	return &ConfigMapCacheBackend{reader: r, writer: w, ref: ref, size: size, refresh: configMapCacheRefresh, now: time.Now}
+}
+
+// Get returns the status cached under the supplied key, or nil if no status is
+// cached. It only reads the ConfigMap if it hasn't read or written it
+// recently, so it may miss statuses recently cached by other replicas.
+func (c *ConfigMapCacheBackend) Get(ctx context.Context, key string) (*CachedStatus, error) {
+	data, err := c.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := data[key]
+	if !ok {
+		return nil, nil
+	}
+	cs := &CachedStatus{}
+	return cs, errors.Wrap(json.Unmarshal([]byte(v), cs), "cannot unmarshal cached status")
+}
+
+// load returns the data of the ConfigMap, reading it if it hasn't been read or
+// written within the refresh interval.
+func (c *ConfigMapCacheBackend) load(ctx context.Context) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.data != nil && c.now().Sub(c.readAt) < c.refresh {
+		return c.data, nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := c.reader.Get(ctx, c.ref, cm); err != nil && !kerrors.IsNotFound(err) {
+		return nil, errors.Wrapf(err, "cannot get ConfigMap %s", c.ref)
+	}
+	c.remember(cm.Data)
+	return c.data, nil
+}
+
+// remember the supplied ConfigMap data. The caller must hold the lock.
+func (c *ConfigMapCacheBackend) remember(data map[string]string) {
+	c.data = make(map[string]string, len(data))
+	for k, v := range data {
+		c.data[k] = v
+	}
+	c.readAt = c.now()
+}
+
+// Set caches the supplied status under the supplied key, evicting the statuses
+// that expire soonest if the cache is full. Concurrent writes by other replicas
+// may cause Set to fail. Caching is best effort, so Set doesn't retry.
+func (c *ConfigMapCacheBackend) Set(ctx context.Context, key string, s CachedStatus) error {
+	v, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "cannot marshal cached status")
+	}
+
+	// Updates must be based on the latest version of the ConfigMap, so
+	// it's always read before it's written.
+	cm := &corev1.ConfigMap{}
+	err = c.reader.Get(ctx, c.ref, cm)
+	if kerrors.IsNotFound(err) {
+		cm.SetNamespace(c.ref.Namespace)
+		cm.SetName(c.ref.Name)
+		cm.Data = map[string]string{key: string(v)}
+		if err := c.writer.Create(ctx, cm); err != nil {
+			return errors.Wrapf(err, "cannot create ConfigMap %s", c.ref)
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.remember(cm.Data)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "cannot get ConfigMap %s", c.ref)
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[key] = string(v)
+
+	statuses := make(map[string]CachedStatus, len(cm.Data))
+	for k, v := range cm.Data {
+		cs := CachedStatus{}
+		// Evict statuses we can't read before any others.
+		_ = json.Unmarshal([]byte(v), &cs)
+		statuses[k] = cs
+	}
+	for _, k := range soonestToExpire(statuses, c.size) {
+		delete(cm.Data, k)
+	}
+
+	if err := c.writer.Update(ctx, cm); err != nil {
+		return errors.Wrapf(err, "cannot update ConfigMap %s", c.ref)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remember(cm.Data)
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2025 The Upbound Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+
+	"github.com/crossplane/function-sdk-go/errors"
+
+	"github.com/upbound/function-claude-status-transformer/input/v1beta1"
+)
+
+// LabelExamples must be set to "true" on ConfigMaps that supply examples. The
+// Function only caches ConfigMaps with this label.
+const LabelExamples = "function-claude-status-transformer.fn.crossplane.io/examples"
+
+// getExamples returns the examples configured by the supplied input, both
+// inline and from its referenced ConfigMap. It returns an error if any
+// example's status doesn't match the shape of CompositionStatus.
+func (f *Function) getExamples(ctx context.Context, in *v1beta1.StatusTransformation) ([]v1beta1.Example, error) {
+	if in.Examples == nil {
+		return nil, nil
+	}
+
+	examples := append([]v1beta1.Example{}, in.Examples.Items...)
+
+	if ref := in.Examples.ConfigMapReference; ref != nil {
+		if f.c == nil {
+			return nil, errors.New("examples.configMapRef requires the Function to be started with --enable-function-configs")
+		}
+
+		cm := &corev1.ConfigMap{}
+		if err := f.c.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, cm); err != nil {
+			return nil, errors.Wrapf(err, "cannot get ConfigMap %s/%s - is it labelled %s=true?", ref.Namespace, ref.Name, LabelExamples)
+		}
+
+		keys := make([]string, 0, len(cm.Data))
+		for k := range cm.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			e := v1beta1.Example{}
+			if err := yaml.Unmarshal([]byte(cm.Data[k]), &e); err != nil {
+				return nil, errors.Wrapf(err, "cannot parse example %q of ConfigMap %s/%s", k, ref.Namespace, ref.Name)
+			}
+			examples = append(examples, e)
+		}
+	}
+
+	for i, e := range examples {
+		if _, err := parseStatus(string(e.Status.Raw)); err != nil {
+			return nil, errors.Wrapf(err, "example %d has an invalid status", i)
+		}
+	}
+
+	return examples, nil
+}
+
+// renderExamples renders the supplied examples for Claude.
+func renderExamples(examples []v1beta1.Example) string {
+	b := &strings.Builder{}
+	b.WriteString("Here are example diagnoses. Match the tone and granularity of the statuses they contain.\n\n<diagnoses>\n")
+	for _, e := range examples {
+		fmt.Fprintf(b, "<diagnosis>\n<resources>\n%s\n</resources>\n<status>\n%s\n</status>\n</diagnosis>\n", strings.TrimSpace(e.Resources), e.Status.Raw)
+	}
+	b.WriteString("</diagnoses>\n")
+	return b.String()
+}
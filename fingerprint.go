@@ -0,0 +1,139 @@
+/*
+Copyright 2025 The Upbound Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/tidwall/gjson"
+
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+const (
+	// conditionTypeClaudeFingerprint records the fingerprint of the
+	// observed state Claude analyzed to produce the last status.
+	conditionTypeClaudeFingerprint xpv1.ConditionType = "ClaudeObservedFingerprint"
+
+	reasonAnalyzed = "Analyzed"
+)
+
+// fingerprintedCondition is the part of a condition that affects Claude's
+// analysis. It omits timestamps, which change without the condition changing.
+type fingerprintedCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// fingerprintedResource is the part of a resource that affects Claude's
+// analysis. A resource's generation changes whenever its spec does.
+type fingerprintedResource struct {
+	APIVersion string                   `json:"apiVersion"`
+	Kind       string                   `json:"kind"`
+	Name       string                   `json:"name"`
+	Namespace  string                   `json:"namespace,omitempty"`
+	Generation int64                    `json:"generation,omitempty"`
+	Conditions []fingerprintedCondition `json:"conditions,omitempty"`
+}
+
+// fingerprint returns a stable fingerprint of the parts of the request that
+// affect Claude's analysis: the Function's input, and the identity, generation,
+// and conditions of the composite and composed resources. It ignores the
+// conditions this Function sets by default, and the supplied conditions.
+func fingerprint(req *fnv1.RunFunctionRequest, ignore ...xpv1.ConditionType) (string, error) {
+	ignored := map[xpv1.ConditionType]bool{
+		conditionTypeClaudeHealthy:     true,
+		conditionTypeClaudeFingerprint: true,
+		conditionTypeClaudeAnalyzed:    true,
+	}
+	for _, t := range ignore {
+		ignored[t] = true
+	}
+
+	xr, err := marshaler.Marshal(req.GetObserved().GetComposite().GetResource())
+	if err != nil {
+		return "", errors.Wrap(err, "cannot marshal observed composite resource to JSON")
+	}
+
+	observed, err := newObservedResources(req.GetObserved().GetResources())
+	if err != nil {
+		return "", errors.Wrap(err, "cannot marshal observed composed resources to JSON")
+	}
+
+	composed := make(map[string]fingerprintedResource, len(observed))
+	for name, j := range observed {
+		composed[name] = fingerprintResource(j, ignored)
+	}
+
+	// encoding/json sorts map keys, so the encoding is stable.
+	j, err := json.Marshal(struct {
+		Input     map[string]any                   `json:"input,omitempty"`
+		Composite fingerprintedResource            `json:"composite"`
+		Composed  map[string]fingerprintedResource `json:"composed,omitempty"`
+	}{
+		Input:     req.GetInput().AsMap(),
+		Composite: fingerprintResource(string(xr), ignored),
+		Composed:  composed,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "cannot marshal fingerprint to JSON")
+	}
+
+	sum := sha256.Sum256(j)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// fingerprintResource returns the part of the supplied JSON manifest that
+// affects Claude's analysis, omitting the supplied ignored conditions.
+func fingerprintResource(manifest string, ignored map[xpv1.ConditionType]bool) fingerprintedResource {
+	r := fingerprintedResource{
+		APIVersion: gjson.Get(manifest, "apiVersion").String(),
+		Kind:       gjson.Get(manifest, "kind").String(),
+		Name:       gjson.Get(manifest, "metadata.name").String(),
+		Namespace:  gjson.Get(manifest, "metadata.namespace").String(),
+		Generation: gjson.Get(manifest, "metadata.generation").Int(),
+	}
+	for _, c := range gjson.Get(manifest, "status.conditions").Array() {
+		if ignored[xpv1.ConditionType(c.Get("type").String())] {
+			continue
+		}
+		r.Conditions = append(r.Conditions, fingerprintedCondition{
+			Type:    c.Get("type").String(),
+			Status:  c.Get("status").String(),
+			Reason:  c.Get("reason").String(),
+			Message: c.Get("message").String(),
+		})
+	}
+	return r
+}
+
+// fingerprintCondition returns the condition of the supplied type that records
+// the supplied fingerprint.
+func fingerprintCondition(t xpv1.ConditionType, fp string) *fnv1.Condition {
+	return &fnv1.Condition{
+		Type:    string(t),
+		Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+		Reason:  reasonAnalyzed,
+		Message: &fp,
+	}
+}
@@ -20,20 +20,27 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"sort"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/bedrock"
 	"github.com/anthropics/anthropic-sdk-go/option"
 	"github.com/anthropics/anthropic-sdk-go/packages/param"
 	"github.com/tidwall/gjson"
+	"golang.org/x/sync/singleflight"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/fieldpath"
 	"github.com/crossplane/function-sdk-go/errors"
 	"github.com/crossplane/function-sdk-go/logging"
 	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
@@ -58,25 +65,53 @@ const prompt = `
 Please follow these instructions carefully:
 
 1. Analyze the provided set of composed resources searching for any resources
-   that are in an unhealthy state. Use the status.conditions field to determine
-   the status of the resource.
+   that are in an unhealthy state. The composed resources are summarized in a
+   table that includes their Ready and Synced conditions. Use the get_resource
+   tool to fetch the full manifest, or selected fields, of any resource you
+   need to inspect more closely, e.g. to read the message of a condition. If
+   the list_events tool is available, use it to find errors that aren't
+   reflected in a resource's conditions.
 
 2. For each resource that is in an unhealthy state, provide a succinct,
    human-readable explanation of the issue. Only include resources that are 
    unhealthy. Use the metadata.name and namespace field to identify the 
-   resource.
-
-3. If there are no unhealthy resources, output an empty "resourceStatuses"
+   resource. If you can, suggest a concrete next step to fix the issue, e.g. a
+   field to change, a missing selector label, or an IAM permission to grant,
+   and the URL of documentation that explains it. Omit suggestions you're
+   unsure of.
+
+3. Categorize the problems of each unhealthy resource as one of:
+   - "Authentication": missing, invalid, or insufficiently privileged
+     credentials.
+   - "Quota": an exceeded quota or rate limit.
+   - "InvalidConfiguration": an invalid spec that must be fixed.
+   - "DependencyNotReady": waiting for another resource that isn't ready.
+   - "Transient": expected to resolve itself, e.g. still being created.
+   - "ProviderError": an error returned by the provider or external system.
+   - "Unknown": fits no other category.
+   Set the overall "category" to that of the problem that caused the
+   composition to fail.
+
+4. Use the dependencies in the <dependencies> tag to tell the root cause of
+   a failure from its symptoms. A resource is often unhealthy only because a
+   resource it depends on is. Set "rootCause" to true for each resource whose
+   problems aren't caused by another resource. Otherwise set "causedBy" to the
+   metadata.name of the resource it depends on whose problems cause its own.
+   List root causes first, and lead the summary with the root cause rather
+   than with the failures it causes.
+
+5. If there are no unhealthy resources, output an empty "resourceStatuses"
    array, an "overallStatus" of "Ready", and a summary of "No unhealthy
-   resources found".
+   resources found". Omit the overall "category".
 
-4. Along with the set of composed resources, I will also provide you with the
+6. Along with the set of composed resources, I will also provide you with the
    last status you. If your summary matches the previous summary and/or
    the resource status messages are still accurate within the status reason,
    return the previous status unchanged.
 
-5. For each explanation, provide a JSON object with the structure shown below in
-   the <example> tag. Submit the JSON object to the submit_status tool.
+7. Submit your findings by calling the submit_status tool. Its input must
+   have the structure shown below in the <example> tag. If the tool reports an
+   error, correct your input and call the tool again.
 </instructions>
 
 <example>
@@ -86,28 +121,41 @@ Please follow these instructions carefully:
 		"namespace": [resource-namespace],
 		"kind": [resource-kind],
 		"apiVersion": [resource-apiVersion],
-		"ready": [true|false],
-		"message": [human-friendly-explanation-of-problems]
+		"ready": false,
+		"message": [human-friendly-explanation-of-problems],
+		"category": [category-of-problems],
+		"suggestedFix": [concrete-next-step-to-fix-problems],
+		"docsURL": [url-of-documentation-explaining-fix],
+		"rootCause": [true|false],
+		"causedBy": [name-of-resource-causing-problems]
 	}],
-	"overallStatus": ["Ready"|"NotReady],
-	"summary": [summary-of-problems]
+	"overallStatus": ["Ready"|"NotReady"],
+	"summary": [summary-of-problems],
+	"category": [category-of-problem-that-caused-the-failure]
 }
 </example>
 `
 
 const vars = `
-Here are the newly composed resources:
+Here is the composite resource:
 
 <composite>
 {{ .Composite }}
 </composite>
 
-If there are any existing composed resources, they will be provided here:
+If there are any existing composed resources, they are summarized here:
 
 <composed>
 {{ .Composed }}
 </composed>
 
+The dependencies of the composed resources, found by following their
+references, selectors, and owner references, are listed here:
+
+<dependencies>
+{{ .Dependencies }}
+</dependencies>
+
 The last status you produced is provided here:
 <last-status>
 {{ .LastStatus }}
@@ -121,18 +169,147 @@ Additional input provided by the Kubernetes operator is provided here:
 `
 
 const (
-	submitStatusToolName             = "submit_status"
-	submitStatusToolSchemaProperties = `{"status_json":{"type": "string","description":"The status object, represented in JSON, to submit"}}`
-	submitStatusToolDescription      = `
-Accepts a JSON object containing a status object. Must be valid JSON in the
-shape supplied in the <example> tag.
+	submitStatusToolName        = "submit_status"
+	submitStatusToolDescription = `
+Submits the status of the composition. The input must be in the shape supplied
+in the <example> tag. Returns an error describing what to fix if the input is
+invalid.
 `
 )
 
 const (
 	conditionTypeClaudeHealthy xpv1.ConditionType = "HealthyAccordingToClaude"
+
+	// conditionTypeClaudeAnalyzed records when Claude last analyzed the
+	// composition. It's only set when the input configures minInterval.
+	conditionTypeClaudeAnalyzed xpv1.ConditionType = "ClaudeLastAnalyzed"
+)
+
+// Default generation parameters, used when the input doesn't configure them.
+const (
+	defaultMaxTokens   = 1024
+	defaultTemperature = 0 // As little randomness as possible.
+)
+
+// minThinkingBudgetTokens is the smallest thinking budget Claude supports.
+const minThinkingBudgetTokens = 1024
+
+// Default limits, used when neither the Function nor its input configure them.
+const (
+	defaultMaxToolRoundTrips = 5
+	defaultMaxOutputTokens   = 4096
+	defaultMaxPromptTokens   = 50000
+	defaultTimeout           = 2 * time.Minute
 )
 
+// Possible values of CompositionStatus.OverallStatus.
+const (
+	overallStatusReady    = "Ready"
+	overallStatusNotReady = "NotReady"
+)
+
+// summaryHealthy is the summary of a composition without unhealthy resources.
+const summaryHealthy = "No unhealthy resources found"
+
+var (
+	// statusRequiredFields are the fields CompositionStatus requires.
+	statusRequiredFields = []string{"resourceStatuses", "overallStatus", "summary"}
+
+	// resourceStatusRequiredFields are the fields composedResourceStatus
+	// requires.
+	resourceStatusRequiredFields = []string{"name", "kind", "apiVersion", "ready", "message"}
+)
+
+// submitStatusToolInputSchema is the JSON schema of the submit_status tool's
+// input. It must be kept in sync with CompositionStatus.
+var submitStatusToolInputSchema = anthropic.ToolInputSchemaParam{
+	Properties: map[string]any{
+		"resourceStatuses": map[string]any{
+			"type":        "array",
+			"description": "The status of each unhealthy composed resource. Empty if all resources are healthy.",
+			"items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"name": map[string]any{
+						"type":        "string",
+						"description": "The metadata.name of the resource.",
+					},
+					"namespace": map[string]any{
+						"type":        "string",
+						"description": "The metadata.namespace of the resource, if it is namespaced.",
+					},
+					"kind": map[string]any{
+						"type":        "string",
+						"description": "The kind of the resource.",
+					},
+					"apiVersion": map[string]any{
+						"type":        "string",
+						"description": "The apiVersion of the resource.",
+					},
+					"ready": map[string]any{
+						"type":        "boolean",
+						"description": "Whether the resource is ready. Always false, because only unhealthy resources are listed.",
+					},
+					"message": map[string]any{
+						"type":        "string",
+						"description": "A succinct, human-friendly explanation of the resource's problems.",
+					},
+					"category": map[string]any{
+						"type":        "string",
+						"enum":        categories,
+						"description": "The category of the resource's problems.",
+					},
+					"suggestedFix": map[string]any{
+						"type":        "string",
+						"description": "A concrete next step to fix the resource's problems, e.g. a field to change. Omitted if unsure.",
+					},
+					"docsURL": map[string]any{
+						"type":        "string",
+						"description": "The http or https URL of documentation that explains the fix. Omitted if unsure.",
+					},
+					"rootCause": map[string]any{
+						"type":        "boolean",
+						"description": "Whether the resource's problems are a root cause of the failure, rather than caused by another resource.",
+					},
+					"causedBy": map[string]any{
+						"type":        "string",
+						"description": "The metadata.name of the resource whose problems cause this resource's problems. Omitted if the resource is a root cause.",
+					},
+				},
+				"required":             resourceStatusRequiredFields,
+				"additionalProperties": false,
+			},
+		},
+		"overallStatus": map[string]any{
+			"type":        "string",
+			"enum":        []string{overallStatusReady, overallStatusNotReady},
+			"description": "Whether the composition as a whole is ready.",
+		},
+		"summary": map[string]any{
+			"type":        "string",
+			"description": "A succinct summary of the problems, or \"No unhealthy resources found\".",
+		},
+		"category": map[string]any{
+			"type":        "string",
+			"enum":        categories,
+			"description": "The category of the problem that caused the composition to fail. Omitted if the composition is ready.",
+		},
+	},
+	Required: statusRequiredFields,
+	ExtraFields: map[string]any{
+		"additionalProperties": false,
+	},
+}
+
+// submitStatusTool lets Claude submit the status of the composition.
+var submitStatusTool = anthropic.ToolUnionParam{
+	OfTool: &anthropic.ToolParam{
+		Name:        submitStatusToolName,
+		Description: anthropic.String(submitStatusToolDescription),
+		InputSchema: submitStatusToolInputSchema,
+	},
+}
+
 var marshaler = protojson.MarshalOptions{
 	UseProtoNames:   true,
 	EmitUnpopulated: false,
@@ -141,7 +318,8 @@ var marshaler = protojson.MarshalOptions{
 
 // composedResourceStatus is the status of a composed resource as reported by
 // Claude. It contains the name of the resource, whether it's ready (which
-// should always be false), and a human-readable explanation of the problems.
+// must always be false, because Claude only reports unhealthy resources), and a
+// human-readable explanation of the problems.
 type composedResourceStatus struct {
 	Name       string `json:"name"`
 	Namespace  string `json:"namespace,omitempty"`
@@ -149,6 +327,23 @@ type composedResourceStatus struct {
 	APIVersion string `json:"apiVersion"`
 	Ready      bool   `json:"ready"`
 	Message    string `json:"message"`
+
+	// Category of the resource's problems.
+	Category string `json:"category,omitempty"`
+
+	// SuggestedFix is a concrete next step to fix the resource's problems.
+	SuggestedFix string `json:"suggestedFix,omitempty"`
+
+	// DocsURL is the URL of documentation that explains the fix.
+	DocsURL string `json:"docsURL,omitempty"`
+
+	// RootCause is true if the resource's problems aren't caused by
+	// another resource.
+	RootCause bool `json:"rootCause,omitempty"`
+
+	// CausedBy is the name of the resource whose problems cause this
+	// resource's problems.
+	CausedBy string `json:"causedBy,omitempty"`
 }
 
 // CompositionStatus is the status of the composition as reported by Claude. It
@@ -158,6 +353,67 @@ type CompositionStatus struct {
 	ResourceStatuses []composedResourceStatus `json:"resourceStatuses"`
 	OverallStatus    string                   `json:"overallStatus"`
 	Summary          string                   `json:"summary"`
+
+	// Category of the problem that caused the composition to fail.
+	Category string `json:"category,omitempty"`
+
+	// Fingerprint is the fingerprint of the observed state Claude analyzed
+	// to produce the status. It isn't sent to or by Claude.
+	Fingerprint string `json:"-"`
+
+	// AnalyzedAt is when Claude produced the status. It's zero unless the
+	// input configures minInterval. It isn't sent to or by Claude.
+	AnalyzedAt time.Time `json:"-"`
+}
+
+// Validate returns an error describing every way in which the status is
+// invalid, or nil if it is valid.
+func (s CompositionStatus) Validate() error {
+	problems := []string{}
+
+	switch s.OverallStatus {
+	case overallStatusReady, overallStatusNotReady:
+	default:
+		problems = append(problems, fmt.Sprintf("overallStatus must be %q or %q, not %q", overallStatusReady, overallStatusNotReady, s.OverallStatus))
+	}
+
+	if strings.TrimSpace(s.Summary) == "" {
+		problems = append(problems, "summary must not be empty")
+	}
+
+	if s.OverallStatus == overallStatusReady && len(s.ResourceStatuses) > 0 {
+		problems = append(problems, fmt.Sprintf("resourceStatuses must be empty when overallStatus is %q", overallStatusReady))
+	}
+
+	if !validCategory(s.Category) {
+		problems = append(problems, fmt.Sprintf("category must be one of %s, not %q", strings.Join(categories, ", "), s.Category))
+	}
+
+	for i, rs := range s.ResourceStatuses {
+		if rs.Ready {
+			problems = append(problems, fmt.Sprintf("resourceStatuses[%d] is ready, but only unhealthy resources may be listed", i))
+		}
+		if strings.TrimSpace(rs.Message) == "" {
+			problems = append(problems, fmt.Sprintf("resourceStatuses[%d].message must not be empty", i))
+		}
+		if !validCategory(rs.Category) {
+			problems = append(problems, fmt.Sprintf("resourceStatuses[%d].category must be one of %s, not %q", i, strings.Join(categories, ", "), rs.Category))
+		}
+		if rs.RootCause && rs.CausedBy != "" {
+			problems = append(problems, fmt.Sprintf("resourceStatuses[%d] is a root cause, so causedBy must be empty", i))
+		}
+		if rs.CausedBy != "" && rs.CausedBy == rs.Name {
+			problems = append(problems, fmt.Sprintf("resourceStatuses[%d].causedBy must name another resource", i))
+		}
+		if u, err := url.Parse(rs.DocsURL); rs.DocsURL != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+			problems = append(problems, fmt.Sprintf("resourceStatuses[%d].docsURL must be an http or https URL, not %q", i, rs.DocsURL))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
 }
 
 // Variables used to form the prompt.
@@ -165,9 +421,12 @@ type Variables struct {
 	// Observed composite resource, as a YAML manifest.
 	Composite string
 
-	// Observed composed resources, as a stream of YAML manifests.
+	// Observed composed resources, as a table summarizing each resource.
 	Composed string
 
+	// Dependencies of the observed composed resources, as a table.
+	Dependencies string
+
 	// Last status you produced.
 	LastStatus string
 
@@ -175,6 +434,61 @@ type Variables struct {
 	Input string
 }
 
+// Limits bound the work done to analyze a composition.
+type Limits struct {
+	// MaxToolRoundTrips is the maximum number of times Claude may be
+	// messaged.
+	MaxToolRoundTrips int
+
+	// MaxOutputTokens is the maximum number of tokens Claude may generate,
+	// summed across all of its responses.
+	MaxOutputTokens int64
+
+	// MaxPromptTokens is the approximate maximum number of tokens the
+	// prompt may use.
+	MaxPromptTokens int64
+
+	// Timeout is the maximum amount of time an analysis may take.
+	Timeout time.Duration
+}
+
+// DefaultLimits are the default Limits of a Function.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxToolRoundTrips: defaultMaxToolRoundTrips,
+		MaxOutputTokens:   defaultMaxOutputTokens,
+		MaxPromptTokens:   defaultMaxPromptTokens,
+		Timeout:           defaultTimeout,
+	}
+}
+
+// For returns the limits that apply to the supplied input. Limits set by the
+// input override these limits.
+func (l Limits) For(in *v1beta1.StatusTransformation) Limits {
+	if in.Limits == nil {
+		return l
+	}
+	if in.Limits.MaxToolRoundTrips != nil {
+		l.MaxToolRoundTrips = *in.Limits.MaxToolRoundTrips
+	}
+	if in.Limits.MaxOutputTokens != nil {
+		l.MaxOutputTokens = *in.Limits.MaxOutputTokens
+	}
+	if in.Limits.MaxPromptTokens != nil {
+		l.MaxPromptTokens = *in.Limits.MaxPromptTokens
+	}
+	if in.Limits.Timeout != nil {
+		l.Timeout = in.Limits.Timeout.Duration
+	}
+	return l
+}
+
+// A MessageClient sends messages to Claude. It's satisfied by the Messages
+// service of an anthropic.Client.
+type MessageClient interface {
+	New(ctx context.Context, body anthropic.MessageNewParams, opts ...option.RequestOption) (*anthropic.Message, error)
+}
+
 // Function asks Claude to compose resources.
 type Function struct {
 	fnv1.UnimplementedFunctionRunnerServiceServer
@@ -183,6 +497,42 @@ type Function struct {
 	log  logging.Logger
 
 	c client.Client
+
+	// reader reads directly from the API server, bypassing the client's
+	// cache. It defaults to c.
+	reader client.Reader
+
+	// now returns the current time.
+	now func() time.Time
+
+	limits Limits
+
+	// listEvents enables the list_events tool. It requires c.
+	listEvents bool
+
+	// newClient returns the MessageClient used to talk to Claude.
+	newClient func(ctx context.Context, in *v1beta1.StatusTransformation, req *fnv1.RunFunctionRequest) (MessageClient, error)
+
+	// cache caches the statuses Claude produces, keyed by the signature of
+	// the failure they diagnose.
+	cache *statusCache
+
+	// throttle bounds the messages sent to Claude by all analyses.
+	throttle *throttle
+
+	// retrier retries messages to Claude that fail with transient errors,
+	// and breaks the circuit to providers that keep failing.
+	retrier *retrier
+
+	// inflight coalesces concurrent analyses of the same observed state.
+	inflight singleflight.Group
+
+	// batches tracks the message batch pending for each XR in batch mode.
+	batches *pendingBatches
+
+	// newBatchClient returns the BatchClient used to talk to Claude in
+	// batch mode.
+	newBatchClient func(ctx context.Context, in *v1beta1.StatusTransformation, req *fnv1.RunFunctionRequest) (BatchClient, error)
 }
 
 // Option enables overrides properties of the Function.
@@ -195,16 +545,81 @@ func WithClient(c client.Client) Option {
 	}
 }
 
+// WithAPIReader overrides the reader the Function uses to read resources that
+// must be up to date, like a FunctionConfig whose status is being updated. It
+// defaults to the Function's client.
+func WithAPIReader(r client.Reader) Option {
+	return func(f *Function) {
+		f.reader = r
+	}
+}
+
+// WithLimits overrides the default Limits of the Function. The Limits may be
+// overridden by the Function's input.
+func WithLimits(l Limits) Option {
+	return func(f *Function) {
+		f.limits = l
+	}
+}
+
+// WithResponseCache caches the statuses Claude produces in the supplied
+// backend for up to ttl, so that compositions that fail for the same reason
+// reuse a prior diagnosis. If maskIdentities is true the names and UIDs of
+// resources are ignored when determining whether two compositions fail for the
+// same reason.
+func WithResponseCache(b CacheBackend, ttl time.Duration, maskIdentities bool) Option {
+	return func(f *Function) {
+		f.cache = newStatusCache(b, ttl, maskIdentities)
+	}
+}
+
+// WithThrottle bounds the messages sent to Claude across every call to the
+// Function.
+func WithThrottle(t Throttle) Option {
+	return func(f *Function) {
+		f.throttle = newThrottle(t)
+	}
+}
+
+// WithRetry overrides how messages to Claude are retried, and when a
+// provider's circuit breaker opens.
+func WithRetry(r Retry) Option {
+	return func(f *Function) {
+		f.retrier = newRetrier(f.log, r)
+	}
+}
+
+// WithListEventsTool lets Claude list the events of the composite and composed
+// resources. The Function's client must be able to list events indexed by
+// eventInvolvedObjectUIDField.
+func WithListEventsTool() Option {
+	return func(f *Function) {
+		f.listEvents = true
+	}
+}
+
 // NewFunction creates a new function powered by Claude.
 func NewFunction(log logging.Logger, opts ...Option) *Function {
 	f := &Function{
-		log:  log,
-		vars: template.Must(template.New("vars").Parse(vars)),
+		log:      log,
+		vars:     template.Must(template.New("vars").Parse(vars)),
+		limits:   DefaultLimits(),
+		cache:    newStatusCache(nil, 0, false),
+		throttle: newThrottle(Throttle{}),
+		retrier:  newRetrier(log, DefaultRetry()),
+		batches:  newPendingBatches(),
+		now:      time.Now,
 	}
 
+	f.newClient = f.getClient
+	f.newBatchClient = f.getBatchClient
+
 	for _, o := range opts {
 		o(f)
 	}
+	if f.reader == nil {
+		f.reader = f.c
+	}
 	return f
 }
 
@@ -221,19 +636,25 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 		return rsp, nil
 	}
 
+	out, err := newOutput(in)
+	if err != nil {
+		response.Fatal(rsp, errors.Wrap(err, "invalid output configuration"))
+		return rsp, nil
+	}
+
 	xr, err := marshaler.Marshal(req.GetObserved().GetComposite())
 	if err != nil {
 		response.Fatal(rsp, errors.Wrap(err, "cannot convert observed XR to YAML"))
 		return rsp, nil
 	}
 
-	cds, err := ProtoMapToJSON(req.GetObserved().GetResources())
+	observed, err := newObservedResources(req.GetObserved().GetResources())
 	if err != nil {
-		response.Fatal(rsp, errors.Wrap(err, "cannot convert observed composed resources to YAML"))
+		response.Fatal(rsp, errors.Wrap(err, "cannot convert observed composed resources to JSON"))
 		return rsp, nil
 	}
 
-	lastStatus, err := lastStatusFromObserved(req)
+	lastStatus, err := lastStatusFromObserved(req, out)
 	if err != nil {
 		response.Warning(rsp, errors.Wrap(err, "cannot get last status from observed"))
 		return rsp, nil
@@ -242,22 +663,168 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 	lastStatusJSON, err := json.Marshal(lastStatus)
 	if err != nil {
 		response.Warning(rsp, errors.Wrap(err, "cannot marshal last status to JSON"))
+		out.Keep(rsp, lastStatus)
+		return rsp, nil
+	}
+
+	if in.MinInterval != nil && in.MinInterval.Duration > 0 {
+		interval := in.MinInterval.Duration
+
+		// Claude analyzed the composition recently. Keep its status,
+		// and ask Crossplane to come back when the next analysis is due.
+		if next := lastStatus.AnalyzedAt.Add(interval); lastStatus.Summary != "" && time.Now().Before(next) {
+			log.Debug("Composition was analyzed recently", "analyzedAt", lastStatus.AnalyzedAt, "nextAnalysis", next)
+			out.Keep(rsp, lastStatus)
+			rsp.Meta.Ttl = durationpb.New(time.Until(next))
+			return rsp, nil
+		}
+
+		// If Claude analyzes the composition now, the next analysis is
+		// due after the interval.
+		defer func() {
+			if out.analyzedAt(rsp).After(lastStatus.AnalyzedAt) {
+				rsp.Meta.Ttl = durationpb.New(interval)
+			}
+		}()
+	}
+
+	fp, err := fingerprint(req, out.healthyType, out.fingerprintType, out.analyzedType)
+	if err != nil {
+		response.Warning(rsp, errors.Wrap(err, "cannot fingerprint observed state"))
+		out.Keep(rsp, lastStatus)
+		return rsp, nil
+	}
+
+	// Nothing Claude analyzes has changed since it produced the last
+	// status, so there's no need to ask it again.
+	if lastStatus.Summary != "" && lastStatus.Fingerprint == fp {
+		log.Debug("Observed state is unchanged since the last analysis", "fingerprint", fp)
+		out.Keep(rsp, lastStatus)
+		return rsp, nil
+	}
+
+	// We marshalled the observed XR above, so this can't fail.
+	xrJSON, _ := marshaler.Marshal(req.GetObserved().GetComposite().GetResource())
+
+	// There's nothing for Claude to explain when everything is healthy.
+	if !in.AnalyzeHealthy && allHealthy(string(xrJSON), observed) {
+		log.Debug("Composite and composed resources are healthy; skipping analysis")
+		out.Set(rsp, CompositionStatus{
+			ResourceStatuses: []composedResourceStatus{},
+			OverallStatus:    overallStatusReady,
+			Summary:          summaryHealthy,
+			Fingerprint:      fp,
+			AnalyzedAt:       analysisTime(in),
+		})
 		return rsp, nil
 	}
 
-	vars := &strings.Builder{}
-	if err := f.vars.Execute(vars, &Variables{Composite: string(xr), Composed: cds, Input: in.AdditionalContext, LastStatus: string(lastStatusJSON)}); err != nil {
-		response.Warning(rsp, errors.Wrap(err, "cannot build prompt from template"))
+	fc, err := f.getFunctionConfig(ctx, in)
+	if err != nil {
+		response.Warning(rsp, errors.Wrap(err, "cannot get FunctionConfig"))
+		out.Keep(rsp, lastStatus)
+		return rsp, nil
+	}
+
+	examples, err := f.getExamples(ctx, in)
+	if err != nil {
+		response.Warning(rsp, errors.Wrap(err, "cannot load examples"))
+		out.Keep(rsp, lastStatus)
+		return rsp, nil
+	}
+
+	examplesText := ""
+	if len(examples) > 0 {
+		examplesText = renderExamples(examples)
+	}
+
+	limits := f.limits.For(in)
+
+	res := promptResources{
+		composite:         string(xr),
+		compositeResource: string(xrJSON),
+		observed:          observed,
+		// Claude can't fetch resources using tools in batch mode, so
+		// it needs every manifest up front.
+		manifests: in.Mode == v1beta1.ModeBatch,
+	}
+
+	// Dependencies help Claude tell the root cause of a failure from its
+	// symptoms.
+	dependencies := observed.DependencySummary()
+
+	// Trim the resources sent to Claude until the prompt fits its budget.
+	var vars string
+	var v *Variables
+	var p Prompt
+	for level := trimNone; ; level++ {
+		var composite, composed string
+		composite, observed, composed = res.Trim(level)
+
+		v = &Variables{Composite: composite, Composed: composed, Dependencies: dependencies, Input: in.AdditionalContext, LastStatus: string(lastStatusJSON)}
+
+		b := &strings.Builder{}
+		if err := f.vars.Execute(b, v); err != nil {
+			response.Warning(rsp, errors.Wrap(err, "cannot build prompt from template"))
+			out.Keep(rsp, lastStatus)
+			return rsp, nil
+		}
+		vars = b.String()
+
+		p, err = buildPrompt(in, fc, v)
+		if err != nil {
+			response.Warning(rsp, errors.Wrap(err, "cannot build customized prompt"))
+			out.Keep(rsp, lastStatus)
+			return rsp, nil
+		}
+
+		tokens := estimateTokens(p.System, p.Instructions, examplesText, vars)
+		if tokens <= limits.MaxPromptTokens {
+			break
+		}
+		if level == trimComposite {
+			response.Warning(rsp, errors.Errorf("prompt of approximately %d tokens exceeds the maximum of %d tokens, even after trimming", tokens, limits.MaxPromptTokens))
+			out.Keep(rsp, lastStatus)
+			return rsp, nil
+		}
+		log.Debug("Trimming prompt to fit its budget", "estimatedTokens", tokens, "maxPromptTokens", limits.MaxPromptTokens, "trimLevel", level+1)
+	}
+
+	gp, err := getGenerationParams(in)
+	if err != nil {
+		response.Warning(rsp, errors.Wrap(err, "invalid anthropic configuration"))
+		out.Keep(rsp, lastStatus)
 		return rsp, nil
 	}
 
-	log.Debug("Using prompt", "prompt", vars.String())
+	sig, err := f.cache.Signature(req, v, string(gp.model), p.System, p.Instructions, examplesText)
+	if err != nil {
+		response.Warning(rsp, errors.Wrap(err, "cannot compute failure signature"))
+		out.Keep(rsp, lastStatus)
+		return rsp, nil
+	}
 
-	client, err := f.getClient(ctx, in, req)
+	// Compositions often fail for the same reason, e.g. because they use
+	// the same broken ProviderConfig. There's no need to ask Claude to
+	// diagnose the same failure twice.
+	status, ok, err := f.cache.Get(ctx, sig)
 	if err != nil {
-		response.Warning(rsp, errors.Wrap(err, "cannot get LLM client"))
+		log.Info("Cannot read diagnosis cache", "error", err)
+	}
+	if ok {
+		log.Debug("Reusing cached diagnosis of an identical failure", "signature", sig.key)
+		status.Fingerprint = fp
+		status.AnalyzedAt = analysisTime(in)
+		out.Set(rsp, status)
+		rsp.Results = append(rsp.Results, &fnv1.Result{
+			Severity: fnv1.Severity_SEVERITY_NORMAL,
+			Message:  "Reused a cached diagnosis of an identical failure",
+		})
 		return rsp, nil
 	}
+	log.Debug("No cached diagnosis of an identical failure", "signature", sig.key)
+
+	log.Debug("Using prompt", "system", p.System, "instructions", p.Instructions, "examples", len(examples), "prompt", vars)
 
 	messages := []anthropic.MessageParam{
 		{
@@ -265,7 +832,7 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 			Content: []anthropic.ContentBlockParamUnion{
 				{
 					OfText: &anthropic.TextBlockParam{
-						Text:         prompt,
+						Text:         p.Instructions,
 						CacheControl: anthropic.NewCacheControlEphemeralParam(),
 					},
 				},
@@ -276,197 +843,486 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 			Content: []anthropic.ContentBlockParamUnion{
 				{
 					OfText: &anthropic.TextBlockParam{
-						Text: vars.String(),
+						Text: vars,
 					},
 				},
 			},
 		},
 	}
 
-	model := getModel(in)
-	for {
-		message, err := client.Messages.New(ctx, anthropic.MessageNewParams{
-			MaxTokens: 1024,
-			Model:     model,
+	// Examples rarely change, so they're cached along with the instructions.
+	if len(examples) > 0 {
+		messages[0].Content = append(messages[0].Content, anthropic.ContentBlockParamUnion{
+			OfText: &anthropic.TextBlockParam{
+				Text:         examplesText,
+				CacheControl: anthropic.NewCacheControlEphemeralParam(),
+			},
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, limits.Timeout)
+	defer cancel()
+
+	tools := []anthropic.ToolUnionParam{
+		{
+			OfTool: &anthropic.ToolParam{
+				Name:        getResourceToolName,
+				Description: anthropic.String(getResourceToolDescription),
+				InputSchema: getResourceToolInputSchema,
+			},
+		},
+		submitStatusTool,
+	}
+
+	var events *eventLister
+	if f.listEvents && f.c != nil {
+		events = &eventLister{c: f.c, composite: string(xrJSON), observed: observed}
+		tools = append(tools, anthropic.ToolUnionParam{
+			OfTool: &anthropic.ToolParam{
+				Name:        listEventsToolName,
+				Description: anthropic.String(listEventsToolDescription),
+				InputSchema: listEventsToolInputSchema,
+			},
+		})
+	}
+
+	// Claude must always either investigate a resource or submit a status.
+	// Thinking isn't compatible with forcing Claude to use a tool, so when
+	// thinking we let Claude choose and remind it if it doesn't use one.
+	toolChoice := anthropic.ToolChoiceUnionParam{OfAny: &anthropic.ToolChoiceAnyParam{}}
+	var thinking anthropic.ThinkingConfigParamUnion
+	if gp.thinkingBudget > 0 {
+		toolChoice = anthropic.ToolChoiceUnionParam{OfAuto: &anthropic.ToolChoiceAutoParam{}}
+		thinking = anthropic.ThinkingConfigParamOfEnabled(gp.thinkingBudget)
+	}
+
+	if in.Mode == v1beta1.ModeBatch {
+		// Claude only responds once in batch mode, so it can't use any
+		// tool but submit_status.
+		if gp.thinkingBudget == 0 {
+			toolChoice = anthropic.ToolChoiceParamOfTool(submitStatusToolName)
+		}
+		return f.runBatch(ctx, rsp, in, out, req, fc, lastStatus, fp, sig, anthropic.MessageBatchNewParamsRequestParams{
+			MaxTokens: min(limits.MaxOutputTokens, gp.thinkingBudget+gp.maxTokens),
+			Model:     gp.model,
 			System: []anthropic.TextBlockParam{
 				{
-					Text:         system,
+					Text:         p.System,
 					CacheControl: anthropic.NewCacheControlEphemeralParam(),
 				},
 			},
-			Temperature: param.Opt[float64]{Value: 0}, // As little randomness as possible.
-			Tools: []anthropic.ToolUnionParam{
-				{
-					OfTool: &anthropic.ToolParam{
-						Name:        submitStatusToolName,
-						Description: anthropic.String(submitStatusToolDescription),
-						InputSchema: anthropic.ToolInputSchemaParam{
-							Properties: map[string]any{
-								"status_stream": map[string]any{
-									"type":        "string",
-									"description": "The status stream, represented in JSON, to submit",
-								},
-							},
-						},
+			Temperature: gp.temperature,
+			TopK:        gp.topK,
+			Thinking:    thinking,
+			Tools:       []anthropic.ToolUnionParam{submitStatusTool},
+			ToolChoice:  toolChoice,
+			Messages:    messages,
+		}), nil
+	}
+
+	analyze := func(ctx context.Context, rsp *fnv1.RunFunctionResponse) {
+		client, err := f.newClient(ctx, in, req)
+		if err != nil {
+			response.Warning(rsp, errors.Wrap(err, "cannot get LLM client"))
+			out.Keep(rsp, lastStatus)
+			return
+		}
+
+		// Tokens count toward the FunctionConfig's budget whether or not
+		// the analysis succeeds.
+		used := int64(0)
+		defer func() { f.recordUsage(ctx, log, fc, used) }()
+
+		promptTokens := estimateTokens(p.System, p.Instructions, examplesText, vars)
+		outputTokens := int64(0)
+
+		// send messages Claude, unless the budget is used or the message
+		// is throttled. If it returns false the analysis must stop; it has
+		// already recorded why in the response.
+		send := func(params anthropic.MessageNewParams) (*anthropic.Message, bool) {
+			if err := checkBudget(fc, f.now(), used); err != nil {
+				response.Warning(rsp, errors.Wrap(err, "stopped messaging Claude"))
+				out.Keep(rsp, lastStatus)
+				return nil, false
+			}
+
+			// Claude's responses are sent back to it with each
+			// message, so they count toward the tokens sent.
+			release, err := f.throttle.Acquire(ctx, promptTokens+outputTokens)
+			if err != nil {
+				log.Debug("Deferring analysis", "error", err)
+				rsp.Results = append(rsp.Results, &fnv1.Result{
+					Severity: fnv1.Severity_SEVERITY_NORMAL,
+					Message:  fmt.Sprintf("Deferred analysis until the next reconcile: %s", err),
+				})
+				out.Keep(rsp, lastStatus)
+				return nil, false
+			}
+
+			message, err := f.retrier.New(ctx, provider(in), client, params)
+			release()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				response.Warning(rsp, errors.Errorf("Claude didn't submit a valid status within the %s timeout", limits.Timeout))
+				out.Keep(rsp, lastStatus)
+				return nil, false
+			}
+			if err != nil {
+				response.Warning(rsp, errors.Wrapf(err, "cannot message Claude"))
+				out.Keep(rsp, lastStatus)
+				return nil, false
+			}
+
+			outputTokens += message.Usage.OutputTokens
+			used += tokensUsed(message.Usage)
+			return message, true
+		}
+
+		// routed records which tier of model diagnosed the composition
+		// when routing is Triage.
+		var routed *fnv1.Result
+
+		// submit sets the status Claude submitted.
+		submit := func(status CompositionStatus) {
+			if err := f.cache.Add(ctx, sig, status); err != nil {
+				log.Info("Cannot cache diagnosis", "error", err)
+			}
+			status.Fingerprint = fp
+			status.AnalyzedAt = analysisTime(in)
+			out.Set(rsp, status)
+			if routed != nil {
+				rsp.Results = append(rsp.Results, routed)
+			}
+		}
+
+		if gp.triageModel != "" {
+			// The triage model can't use tools, so it must respond in
+			// one message. It doesn't think, so it's as deterministic
+			// as possible.
+			message, ok := send(anthropic.MessageNewParams{
+				MaxTokens: min(limits.MaxOutputTokens, gp.triageMaxTokens),
+				Model:     gp.triageModel,
+				System: []anthropic.TextBlockParam{
+					{
+						Text:         p.System,
+						CacheControl: anthropic.NewCacheControlEphemeralParam(),
 					},
 				},
-			},
-			Messages: messages,
-		})
-		if err != nil {
-			response.Warning(rsp, errors.Wrapf(err, "cannot message Claude"))
-			return rsp, nil
+				Temperature: anthropic.Float(defaultTemperature),
+				Tools:       []anthropic.ToolUnionParam{triageTool},
+				ToolChoice:  anthropic.ToolChoiceParamOfTool(triageToolName),
+				Messages:    append(messages[:len(messages):len(messages)], triageMessage()),
+			})
+			if !ok {
+				return
+			}
+
+			status, err := triageStatus(message)
+			if err == nil {
+				log.Debug("Triage model diagnosed the composition", "model", gp.triageModel, "overallStatus", status.OverallStatus)
+				routed = &fnv1.Result{
+					Severity: fnv1.Severity_SEVERITY_NORMAL,
+					Message:  fmt.Sprintf("Diagnosed by triage model %s", gp.triageModel),
+					Reason:   ptr.To(tierTriage),
+				}
+				submit(status)
+				return
+			}
+
+			log.Debug("Escalating analysis", "model", gp.model, "reason", err)
+			routed = &fnv1.Result{
+				Severity: fnv1.Severity_SEVERITY_NORMAL,
+				Message:  fmt.Sprintf("Escalated to model %s: %s", gp.model, err),
+				Reason:   ptr.To(tierEscalated),
+			}
 		}
 
-		// Save Claude's response, to feed back to it on the next call.
-		messages = append(messages, message.ToParam())
+		for roundTrip := 1; roundTrip <= limits.MaxToolRoundTrips; roundTrip++ {
+			// Claude requires room to respond after it has finished thinking.
+			remaining := limits.MaxOutputTokens - outputTokens
+			if remaining <= gp.thinkingBudget {
+				response.Warning(rsp, errors.Errorf("Claude didn't submit a valid status before generating the maximum of %d output tokens", limits.MaxOutputTokens))
+				out.Keep(rsp, lastStatus)
+				return
+			}
 
-		toolResults := []anthropic.ContentBlockParamUnion{}
-		for _, block := range message.Content {
-			switch block.AsAny().(type) {
+			message, ok := send(anthropic.MessageNewParams{
+				MaxTokens: min(remaining, gp.thinkingBudget+gp.maxTokens),
+				Model:     gp.model,
+				System: []anthropic.TextBlockParam{
+					{
+						Text:         p.System,
+						CacheControl: anthropic.NewCacheControlEphemeralParam(),
+					},
+				},
+				Temperature: gp.temperature,
+				TopK:        gp.topK,
+				Thinking:    thinking,
+				Tools:       tools,
+				ToolChoice:  toolChoice,
+				Messages:    messages,
+			})
+			if !ok {
+				return
+			}
+			log.Debug("Received message from Claude", "roundTrip", roundTrip, "stopReason", message.StopReason, "outputTokens", outputTokens)
 
-			// This could happen several times, as Claude calls the
-			// tool to check whether its YAML is valid.
-			case anthropic.ToolUseBlock:
-				log.Debug("Got tool use block from Claude", "tool_name", block.Name, "tool_input", block.JSON.Input.Raw())
+			// Save Claude's response, to feed back to it on the next call.
+			messages = append(messages, message.ToParam())
 
-				switch block.Name {
-				case submitStatusToolName:
-					y := gjson.Get(block.JSON.Input.Raw(), "status_stream").String()
-					if y == "" {
-						response.Fatal(rsp, errors.Errorf("Claude didn't provide 'status_stream' input property for %q tool", block.Name))
-						return rsp, nil
-					}
+			toolResults := []anthropic.ContentBlockParamUnion{}
+			for _, block := range message.Content {
+				switch block.AsAny().(type) {
 
-					var status CompositionStatus
-					result := ""
-					if err := json.Unmarshal([]byte(y), &status); err != nil {
-						result = err.Error()
-					} else {
-						log.Debug("Received composition status from Claude",
-							"overallStatus", status.OverallStatus,
-							"summary", status.Summary,
-							"resourceCount", len(status.ResourceStatuses))
+				// This could happen several times, as Claude fetches
+				// resources and retries the submit_status tool until the
+				// status it submits is valid.
+				case anthropic.ToolUseBlock:
+					log.Debug("Got tool use block from Claude", "tool_name", block.Name, "tool_input", block.JSON.Input.Raw())
 
-						jsonStatuses, err := json.Marshal(status.ResourceStatuses)
+					switch block.Name {
+					case getResourceToolName:
+						result, err := observed.GetResource(block.JSON.Input.Raw())
 						if err != nil {
-							response.Fatal(rsp, errors.Wrap(err, "cannot marshal resource statuses to JSON"))
-							return rsp, nil
+							log.Debug("Claude asked for an invalid resource", "error", err)
+							toolResults = append(toolResults, anthropic.NewToolResultBlock(block.ID, err.Error(), true))
+							continue
 						}
+						toolResults = append(toolResults, anthropic.NewToolResultBlock(block.ID, result, false))
 
-						cond := &fnv1.Condition{
-							Type:    string(conditionTypeClaudeHealthy),
-							Message: &status.Summary,
-							Reason:  string(jsonStatuses),
+					case listEventsToolName:
+						if events == nil {
+							response.Warning(rsp, errors.Errorf("Claude tried to use disabled tool %q", block.Name))
+							out.Keep(rsp, lastStatus)
+							return
 						}
+						result, err := events.ListEvents(ctx, block.JSON.Input.Raw())
+						if err != nil {
+							log.Debug("Cannot list events", "error", err)
+							toolResults = append(toolResults, anthropic.NewToolResultBlock(block.ID, err.Error(), true))
+							continue
+						}
+						toolResults = append(toolResults, anthropic.NewToolResultBlock(block.ID, result, false))
 
-						if status.OverallStatus == "Ready" {
-							cond.Status = fnv1.Status_STATUS_CONDITION_TRUE
-						} else {
-							cond.Status = fnv1.Status_STATUS_CONDITION_FALSE
+					case submitStatusToolName:
+						status, err := parseStatus(block.JSON.Input.Raw())
+						if err != nil {
+							// Tell Claude what's wrong so it can try again.
+							log.Debug("Claude submitted an invalid status", "error", err)
+							toolResults = append(toolResults, anthropic.NewToolResultBlock(block.ID, err.Error(), true))
+							continue
 						}
 
-						rsp.Conditions = append(rsp.Conditions, cond)
+						log.Debug("Received composition status from Claude",
+							"overallStatus", status.OverallStatus,
+							"summary", status.Summary,
+							"resourceCount", len(status.ResourceStatuses))
 
-						rsp.Results = append(rsp.Results, &fnv1.Result{
-							Severity: fnv1.Severity_SEVERITY_NORMAL,
-							Message:  status.Summary,
-							Reason:   ptr.To(string(jsonStatuses)),
-						})
+						submit(status)
+						return
 
-						return rsp, nil
+					default:
+						response.Warning(rsp, errors.Errorf("Claude tried to use unknown tool %q", block.Name))
+						out.Keep(rsp, lastStatus)
+						return
 					}
 
-					log.Debug("Submitted status stream", "result", result, "isError", result != "")
-					toolResults = append(toolResults, anthropic.NewToolResultBlock(block.ID, result, result != ""))
-
-				default:
-					response.Warning(rsp, errors.Errorf("Claude tried to use unknown tool %q", block.Name))
-					return rsp, nil
+				// Unless it's thinking, we force Claude to call a tool so it
+				// shouldn't send text. Log it in case it does.
+				case anthropic.TextBlock:
+					log.Debug("Received text block from Claude", "text", block.Text)
+
+				// Thinking blocks are replayed to Claude as part of its
+				// response above, which it requires to continue using tools.
+				case anthropic.ThinkingBlock:
+					log.Debug("Received thinking block from Claude", "thinking", block.Thinking)
+				case anthropic.RedactedThinkingBlock:
+					log.Debug("Received redacted thinking block from Claude")
 				}
+			}
 
-			// Despite the prompt, Claude insists on sending a text
-			// message explaining what it's going to do before it
-			// calls the tool. So this could be called several
-			// times, and only sometimes with YAML.
-			case anthropic.TextBlock:
-				log.Debug("Received text block from Claude", "text", block.Text)
+			// When thinking, Claude may end its turn without calling a tool.
+			// Remind it to submit a status.
+			if len(toolResults) == 0 && gp.thinkingBudget > 0 && message.StopReason == anthropic.StopReasonEndTurn {
+				messages = append(messages, anthropic.NewUserMessage(anthropic.NewTextBlock(fmt.Sprintf("Submit your findings by calling the %s tool.", submitStatusToolName))))
+				continue
 			}
-		}
 
-		// Claude's done using tools.
-		if len(toolResults) == 0 {
-			break
+			// We force Claude to call a tool, so a response without a tool
+			// call means something went wrong (e.g. it ran out of tokens).
+			if len(toolResults) == 0 {
+				response.Warning(rsp, errors.Errorf("Claude's response didn't call a tool (stop reason %q)", message.StopReason))
+				out.Keep(rsp, lastStatus)
+				return
+			}
+
+			// Claude fetched resources or submitted an invalid status. Send
+			// the messages again, this time with the tool results.
+			messages = append(messages, anthropic.NewUserMessage(toolResults...))
 		}
 
-		// Claude's not done using tools. Send the messages again, this
-		// time with the tool results.
-		messages = append(messages, anthropic.NewUserMessage(toolResults...))
+		response.Warning(rsp, errors.Errorf("Claude didn't submit a valid status within the maximum of %d tool round trips", limits.MaxToolRoundTrips))
+		out.Keep(rsp, lastStatus)
+		return
 	}
 
-	// We should never get here.
-	response.Warning(rsp, errors.New("Claude didn't return a YAML stream of composed resource manifests"))
+	// Crossplane may call the Function for the same XR several times in
+	// quick succession. Concurrent calls that observe the same state share
+	// one analysis, so Claude is only messaged once.
+	key := gjson.Get(string(xrJSON), "metadata.uid").String() + "/" + fp
+	ch := f.inflight.DoChan(key, func() (any, error) {
+		// The analysis is shared, so it shouldn't be cancelled when
+		// the call that started it is.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), limits.Timeout)
+		defer cancel()
+
+		rsp := &fnv1.RunFunctionResponse{}
+		analyze(ctx, rsp)
+		return rsp, nil
+	})
+
+	// Don't wait for the shared analysis past this call's deadline. The
+	// analysis continues, and its status is cached for later calls.
+	var shared singleflight.Result
+	select {
+	case shared = <-ch:
+	case <-ctx.Done():
+		response.Warning(rsp, errors.Wrap(ctx.Err(), "stopped waiting for a concurrent analysis of the same observed state"))
+		out.Keep(rsp, lastStatus)
+		return rsp, nil
+	}
+	if shared.Shared {
+		log.Debug("Shared a concurrent analysis of the same observed state", "fingerprint", fp)
+	}
+
+	// Each caller gets its own copy of the shared conditions, results, and
+	// status.
+	a := proto.Clone(shared.Val.(*fnv1.RunFunctionResponse)).(*fnv1.RunFunctionResponse) //nolint:forcetypeassert // We only return *fnv1.RunFunctionResponse.
+	rsp.Conditions = append(rsp.Conditions, a.GetConditions()...)
+	rsp.Results = append(rsp.Results, a.GetResults()...)
+	out.Copy(a, rsp)
 	return rsp, nil
 }
 
-// ProtoMapToJSON converts a map of string keys to proto messages into a single JSON string
-// suitable for LLM consumption. Returns the JSON-encoded string of the entire map.
-func ProtoMapToJSON(protoMap map[string]*fnv1.Resource) (string, error) {
-	result := make(map[string]interface{})
-
-	for key, protoMsg := range protoMap {
-		if protoMsg == nil {
-			result[key] = nil
-			continue
+// allHealthy returns true if the supplied composite resource and every
+// composed resource are both Ready and Synced.
+func allHealthy(xr string, observed observedResources) bool {
+	if !healthy(xr) {
+		return false
+	}
+	for _, j := range observed {
+		if !healthy(j) {
+			return false
 		}
+	}
+	return true
+}
 
-		jsonBytes, err := marshaler.Marshal(protoMsg)
-		if err != nil {
-			return "", fmt.Errorf("failed to marshal proto message for key '%s': %w", key, err)
-		}
+// healthy returns true if the supplied JSON manifest has Ready and Synced
+// conditions that are both True. A resource without either condition, or with
+// either condition Unknown, isn't healthy. It's the single rule used both to
+// skip analysis and to trim healthy resources from the prompt.
+func healthy(manifest string) bool {
+	ready := gjson.Get(manifest, `status.conditions.#(type=="Ready").status`).String()
+	synced := gjson.Get(manifest, `status.conditions.#(type=="Synced").status`).String()
+	return ready == "True" && synced == "True"
+}
 
-		// Unmarshal JSON bytes back into interface{} using the keys output by
-		// the proto marshaler.
-		var jsonObj interface{}
-		if err := json.Unmarshal(jsonBytes, &jsonObj); err != nil {
-			return "", fmt.Errorf("failed to unmarshal JSON for key '%s': %w", key, err)
-		}
+// analysisTime returns the time to record for an analysis that finishes now.
+// It returns the zero time if the supplied input doesn't configure
+// minInterval, since the time is only needed to enforce it.
+func analysisTime(in *v1beta1.StatusTransformation) time.Time {
+	if in.MinInterval == nil || in.MinInterval.Duration <= 0 {
+		return time.Time{}
+	}
+	return time.Now()
+}
 
-		result[key] = jsonObj
+// parseStatus parses and validates the input Claude supplied to the
+// submit_status tool. The returned error is intended to be sent back to Claude
+// so that it can correct its input.
+func parseStatus(input string) (CompositionStatus, error) {
+	status := CompositionStatus{}
+	if !gjson.Valid(input) {
+		return status, errors.New("input is not valid JSON")
 	}
 
-	// Marshal the entire map to a single JSON string
-	finalJSON, err := json.MarshalIndent(result, "", "  ")
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal final JSON: %w", err)
+	problems := []string{}
+	for _, f := range statusRequiredFields {
+		if !gjson.Get(input, f).Exists() {
+			problems = append(problems, fmt.Sprintf("missing required field %q", f))
+		}
+	}
+	for i, rs := range gjson.Get(input, "resourceStatuses").Array() {
+		for _, f := range resourceStatusRequiredFields {
+			if !rs.Get(f).Exists() {
+				problems = append(problems, fmt.Sprintf("missing required field \"resourceStatuses[%d].%s\"", i, f))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return status, errors.New(strings.Join(problems, "; "))
 	}
 
-	return string(finalJSON), nil
+	d := json.NewDecoder(strings.NewReader(input))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&status); err != nil {
+		return status, errors.Wrap(err, "input does not match the schema")
+	}
+
+	return status, status.Validate()
 }
 
-func lastStatusFromObserved(req *fnv1.RunFunctionRequest) (CompositionStatus, error) {
+// lastStatusFromObserved returns the last status Claude produced, as recorded
+// by the observed composite resource. The status is read from the supplied
+// output's path of the composite resource's status if it exists, and otherwise
+// from the output's condition, whose reason is the JSON encoded status of each
+// composed resource in Reason output mode.
+func lastStatusFromObserved(req *fnv1.RunFunctionRequest, o output) (CompositionStatus, error) {
 	oxr, err := request.GetObservedCompositeResource(req)
 	if err != nil {
 		return CompositionStatus{}, errors.Wrap(err, "cannot get observed composite resource")
 	}
 
-	cond := oxr.Resource.GetCondition(conditionTypeClaudeHealthy)
+	status := CompositionStatus{}
+
+	written, err := oxr.Resource.GetValue(o.path)
+	switch {
+	case err == nil:
+		// The value was unmarshalled from JSON, so this can't fail.
+		j, _ := json.Marshal(written)
+		if err := json.Unmarshal(j, &status); err != nil {
+			return CompositionStatus{}, errors.Wrapf(err, "cannot unmarshal last status from %s", o.path)
+		}
+	case !fieldpath.IsNotFound(err):
+		return CompositionStatus{}, errors.Wrapf(err, "cannot get last status from %s", o.path)
+	default:
+		cond := oxr.Resource.GetCondition(o.healthyType)
+		status.Summary = cond.Message
+		if cond.Status == corev1.ConditionTrue {
+			status.OverallStatus = overallStatusReady
+		} else {
+			status.OverallStatus = overallStatusNotReady
+		}
+		// Only Reason output mode encodes resource statuses in the
+		// reason. Status output mode uses a short reason.
+		if strings.HasPrefix(string(cond.Reason), "[") {
+			if err := json.Unmarshal([]byte(cond.Reason), &status.ResourceStatuses); err != nil {
+				return CompositionStatus{}, errors.Wrap(err, "cannot unmarshal resource statuses from condition reason")
+			}
+		}
+	}
 
-	status := CompositionStatus{
-		Summary:          cond.Message,
-		ResourceStatuses: []composedResourceStatus{},
+	if status.ResourceStatuses == nil {
+		status.ResourceStatuses = []composedResourceStatus{}
 	}
 
-	if len(cond.Reason) != 0 {
-		if err := json.Unmarshal([]byte(cond.Reason), &status.ResourceStatuses); err != nil {
-			return CompositionStatus{}, errors.Wrap(err, "cannot unmarshal resource statuses from condition reason")
-		}
+	if fc := oxr.Resource.GetCondition(o.fingerprintType); fc.Status == corev1.ConditionTrue {
+		status.Fingerprint = fc.Message
 	}
 
-	if cond.Status == corev1.ConditionTrue {
-		status.OverallStatus = "Ready"
-	} else {
-		status.OverallStatus = "NotReady"
+	// A time we can't parse is treated as never having been analyzed.
+	if ac := oxr.Resource.GetCondition(o.analyzedType); ac.Status == corev1.ConditionTrue {
+		status.AnalyzedAt, _ = time.Parse(time.RFC3339, ac.Message)
 	}
 
 	return status, nil
@@ -475,12 +1331,36 @@ func lastStatusFromObserved(req *fnv1.RunFunctionRequest) (CompositionStatus, er
 const (
 	defaultAWSRegion       = "us-east-1"
 	defaultAWSBedrockModel = "us.anthropic.claude-sonnet-4-20250514-v1:0"
+	defaultAnthropicModel  = anthropic.ModelClaudeSonnet4_0
 )
 
-// getClient returns an anthropic.Client configured to either use Anthropic's
+// modelCapabilities are the capabilities of a Claude model.
+type modelCapabilities struct {
+	// maxOutputTokens is the maximum number of tokens the model may
+	// generate in a single response.
+	maxOutputTokens int64
+
+	// thinking is true if the model supports extended thinking.
+	thinking bool
+}
+
+// anthropicModels are the models that may be used with Anthropic's API.
+var anthropicModels = map[anthropic.Model]modelCapabilities{
+	anthropic.ModelClaudeOpus4_0:           {maxOutputTokens: 32000, thinking: true},
+	anthropic.ModelClaudeOpus4_20250514:    {maxOutputTokens: 32000, thinking: true},
+	anthropic.ModelClaudeSonnet4_0:         {maxOutputTokens: 64000, thinking: true},
+	anthropic.ModelClaudeSonnet4_20250514:  {maxOutputTokens: 64000, thinking: true},
+	anthropic.ModelClaude3_7SonnetLatest:   {maxOutputTokens: 64000, thinking: true},
+	anthropic.ModelClaude3_7Sonnet20250219: {maxOutputTokens: 64000, thinking: true},
+	anthropic.ModelClaude3_5HaikuLatest:    {maxOutputTokens: 8192},
+	anthropic.ModelClaude3_5Haiku20241022:  {maxOutputTokens: 8192},
+	anthropic.ModelClaude_3_Haiku_20240307: {maxOutputTokens: 4096},
+}
+
+// getClient returns a MessageClient configured to either use Anthropic's
 // APIs directly, using a standard API key, or AWS Bedrock which uses AWS
 // authentication methods (including PRODIC from Upbound).
-func (f *Function) getClient(ctx context.Context, in *v1beta1.StatusTransformation, req *fnv1.RunFunctionRequest) (anthropic.Client, error) {
+func (f *Function) getClient(ctx context.Context, in *v1beta1.StatusTransformation, req *fnv1.RunFunctionRequest) (MessageClient, error) {
 	if in.UseAWS() {
 		// Ensure the region is default if it's not provided.
 		if len(in.AWS.Region) == 0 {
@@ -490,19 +1370,30 @@ func (f *Function) getClient(ctx context.Context, in *v1beta1.StatusTransformati
 		a := caws.New(f.c, in, req)
 		cfg, err := a.GetConfig(ctx)
 		if err != nil {
-			return anthropic.Client{}, errors.Wrap(err, "failed to derive AWS Config from the environment")
+			return nil, errors.Wrap(err, "failed to derive AWS Config from the environment")
 		}
 
-		return anthropic.NewClient(bedrock.WithConfig(*cfg)), nil
+		// The Function retries messages itself.
+		c := anthropic.NewClient(bedrock.WithConfig(*cfg), option.WithMaxRetries(0))
+		return &c.Messages, nil
 	}
 
 	a := canthropic.New(req)
 	key, err := a.GetAPIKey()
 	if err != nil {
-		return anthropic.Client{}, errors.Wrap(err, "failed to retrieve Anthropic API key")
+		return nil, errors.Wrap(err, "failed to retrieve Anthropic API key")
 	}
 
-	return anthropic.NewClient(option.WithAPIKey(key)), nil
+	c := anthropic.NewClient(option.WithAPIKey(key), option.WithMaxRetries(0))
+	return &c.Messages, nil
+}
+
+// provider returns the provider of Claude used with the incoming request.
+func provider(in *v1beta1.StatusTransformation) string {
+	if in.UseAWS() {
+		return providerBedrock
+	}
+	return providerAnthropic
 }
 
 // getModel returns the anthropic.Model that should be used with the incoming
@@ -515,5 +1406,128 @@ func getModel(in *v1beta1.StatusTransformation) anthropic.Model {
 		}
 		return anthropic.Model(in.AWS.Bedrock.ModelID)
 	}
-	return anthropic.ModelClaudeSonnet4_0
+	if in.Anthropic == nil {
+		in.Anthropic = &v1beta1.Anthropic{}
+	}
+	if len(in.Anthropic.Model) == 0 {
+		in.Anthropic.Model = string(defaultAnthropicModel)
+	}
+	return anthropic.Model(in.Anthropic.Model)
+}
+
+// generationParams are the parameters Claude uses to generate its responses.
+type generationParams struct {
+	model       anthropic.Model
+	maxTokens   int64
+	temperature param.Opt[float64]
+	topK        param.Opt[int64]
+
+	// thinkingBudget is the number of tokens Claude may use to think in
+	// each response. Zero if thinking is disabled.
+	thinkingBudget int64
+
+	// triageModel triages the composition before the model analyzes it.
+	// Empty unless routing is Triage.
+	triageModel anthropic.Model
+
+	// triageMaxTokens is the maximum number of tokens the triage model may
+	// generate.
+	triageMaxTokens int64
+}
+
+// supportedModels returns the models that may be used with Anthropic's API,
+// as a comma separated list.
+func supportedModels() string {
+	supported := make([]string, 0, len(anthropicModels))
+	for m := range anthropicModels {
+		supported = append(supported, string(m))
+	}
+	sort.Strings(supported)
+	return strings.Join(supported, ", ")
+}
+
+// getGenerationParams returns the generationParams that should be used with
+// the incoming request. Parameters configured for Anthropic's API are defaulted
+// and validated against the capabilities of the model.
+func getGenerationParams(in *v1beta1.StatusTransformation) (generationParams, error) { //nolint:gocyclo // Just a long list of validations.
+	gp := generationParams{
+		model:       getModel(in),
+		maxTokens:   defaultMaxTokens,
+		temperature: anthropic.Float(defaultTemperature),
+	}
+
+	// We don't know the capabilities of Bedrock models, so we leave it to
+	// Bedrock to validate them.
+	var mc *modelCapabilities
+	if !in.UseAWS() {
+		c, ok := anthropicModels[gp.model]
+		if !ok {
+			return gp, errors.Errorf("unsupported model %q, must be one of %s", gp.model, supportedModels())
+		}
+		mc = &c
+
+		a := in.Anthropic
+		if a.MaxTokens != nil {
+			if *a.MaxTokens < 1 || *a.MaxTokens > mc.maxOutputTokens {
+				return gp, errors.Errorf("maxTokens must be between 1 and %d for model %q, not %d", mc.maxOutputTokens, gp.model, *a.MaxTokens)
+			}
+			gp.maxTokens = *a.MaxTokens
+		}
+		if a.Temperature != nil {
+			if *a.Temperature < 0 || *a.Temperature > 1 {
+				return gp, errors.Errorf("temperature must be between 0 and 1, not %v", *a.Temperature)
+			}
+			gp.temperature = anthropic.Float(*a.Temperature)
+		}
+		if a.TopK != nil {
+			if *a.TopK < 1 {
+				return gp, errors.Errorf("topK must be at least 1, not %d", *a.TopK)
+			}
+			gp.topK = anthropic.Int(*a.TopK)
+		}
+	}
+
+	// Claude only responds once in batch mode, so there's no opportunity
+	// to escalate.
+	if in.Routing == v1beta1.RoutingTriage && in.Mode != v1beta1.ModeBatch {
+		gp.triageModel = getTriageModel(in)
+		gp.triageMaxTokens = gp.maxTokens
+		if mc != nil {
+			tc, ok := anthropicModels[gp.triageModel]
+			if !ok {
+				return gp, errors.Errorf("unsupported triage model %q, must be one of %s", gp.triageModel, supportedModels())
+			}
+			gp.triageMaxTokens = min(gp.maxTokens, tc.maxOutputTokens)
+		}
+	}
+
+	if in.Thinking == nil {
+		return gp, nil
+	}
+
+	budget := in.Thinking.BudgetTokens
+	if budget < minThinkingBudgetTokens {
+		return gp, errors.Errorf("thinking budgetTokens must be at least %d, not %d", minThinkingBudgetTokens, budget)
+	}
+	if mc != nil {
+		if !mc.thinking {
+			return gp, errors.Errorf("model %q doesn't support thinking", gp.model)
+		}
+		if budget+gp.maxTokens > mc.maxOutputTokens {
+			return gp, errors.Errorf("thinking budgetTokens plus maxTokens must not exceed %d for model %q, not %d", mc.maxOutputTokens, gp.model, budget+gp.maxTokens)
+		}
+	}
+
+	// Thinking isn't compatible with a temperature other than 1, or with
+	// top K sampling. We omit the temperature, which defaults to 1.
+	if !in.UseAWS() && in.Anthropic.Temperature != nil && *in.Anthropic.Temperature != 1 {
+		return gp, errors.Errorf("temperature must be 1 when thinking is enabled, not %v", *in.Anthropic.Temperature)
+	}
+	if gp.topK.Valid() {
+		return gp, errors.New("topK can't be set when thinking is enabled")
+	}
+	gp.temperature = param.Opt[float64]{}
+	gp.thinkingBudget = budget
+
+	return gp, nil
 }
@@ -18,25 +18,233 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/anthropics/anthropic-sdk-go/packages/jsonl"
+	"github.com/anthropics/anthropic-sdk-go/packages/param"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
+	"github.com/crossplane/function-sdk-go/errors"
 	"github.com/crossplane/function-sdk-go/logging"
 	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/response"
+
+	"github.com/upbound/function-claude-status-transformer/input/v1alpha1"
+	"github.com/upbound/function-claude-status-transformer/input/v1beta1"
 )
 
+// fakeMessageClient replays canned Claude responses, recording the requests it
+// receives.
+type fakeMessageClient struct {
+	// responses are JSON encoded anthropic.Messages, returned in order.
+	responses []string
+
+	calls []anthropic.MessageNewParams
+}
+
+func (c *fakeMessageClient) New(_ context.Context, body anthropic.MessageNewParams, _ ...option.RequestOption) (*anthropic.Message, error) {
+	c.calls = append(c.calls, body)
+	if len(c.calls) > len(c.responses) {
+		return nil, errors.New("unexpected call to Claude")
+	}
+	m := &anthropic.Message{}
+	err := json.Unmarshal([]byte(c.responses[len(c.calls)-1]), m)
+	return m, err
+}
+
+// blockingMessageClient returns a canned Claude response once released,
+// counting the requests it receives. It's safe for concurrent use.
+type blockingMessageClient struct {
+	response string
+
+	// started is closed when the first request is received.
+	started chan struct{}
+	release chan struct{}
+
+	calls atomic.Int32
+}
+
+func (c *blockingMessageClient) New(_ context.Context, _ anthropic.MessageNewParams, _ ...option.RequestOption) (*anthropic.Message, error) {
+	if c.calls.Add(1) == 1 {
+		close(c.started)
+	}
+	<-c.release
+	m := &anthropic.Message{}
+	err := json.Unmarshal([]byte(c.response), m)
+	return m, err
+}
+
+// flakyMessageClient returns errors in order, then replays canned Claude
+// responses.
+type flakyMessageClient struct {
+	fakeMessageClient
+
+	errs   []error
+	failed int
+}
+
+func (c *flakyMessageClient) New(ctx context.Context, body anthropic.MessageNewParams, opts ...option.RequestOption) (*anthropic.Message, error) {
+	if c.failed < len(c.errs) {
+		c.failed++
+		return nil, c.errs[c.failed-1]
+	}
+	return c.fakeMessageClient.New(ctx, body, opts...)
+}
+
+// apiError returns an error like those returned by Anthropic's API.
+func apiError(code int) error {
+	req, _ := http.NewRequest(http.MethodPost, "https://api.anthropic.com/v1/messages", nil)
+	return &anthropic.Error{StatusCode: code, Request: req, Response: &http.Response{StatusCode: code, Header: http.Header{}}}
+}
+
+// toolUse returns a JSON encoded anthropic.Message that calls the named tool
+// with the supplied input.
+func toolUse(id, name, input string) string {
+	return fmt.Sprintf(`{"id":"msg-%s","type":"message","role":"assistant","model":"claude","stop_reason":"tool_use","usage":{"input_tokens":100,"output_tokens":50},"content":[{"type":"tool_use","id":%q,"name":%q,"input":%s}]}`, id, id, name, input)
+}
+
 func TestRunFunction(t *testing.T) {
+	input := resource.MustStructJSON(`{
+		"apiVersion": "function-claude-status-transformer.fn.crossplane.io/v1beta1",
+		"kind": "StatusTransformation",
+		"additionalContext": ""
+	}`)
+	xr := resource.MustStructJSON(`{
+		"apiVersion": "example.org/v1",
+		"kind": "XR",
+		"metadata": {"name": "cool-xr"}
+	}`)
+	db := resource.MustStructJSON(`{
+		"apiVersion": "rds.aws.upbound.io/v1beta1",
+		"kind": "RDSInstance",
+		"metadata": {"name": "cool-db"},
+		"status": {"conditions": [{
+			"type": "Ready",
+			"status": "False",
+			"reason": "ReconcileError",
+			"message": "Subnet not found"
+		}]}
+	}`)
+
+	xrOnly := &fnv1.RunFunctionRequest{
+		Input:    input,
+		Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: xr}},
+	}
+	xrAndDB := &fnv1.RunFunctionRequest{
+		Input: input,
+		Observed: &fnv1.State{
+			Composite: &fnv1.Resource{Resource: xr},
+			Resources: map[string]*fnv1.Resource{
+				"db": {Resource: db},
+			},
+		},
+	}
+	thinking := &fnv1.RunFunctionRequest{
+		Input: resource.MustStructJSON(`{
+			"apiVersion": "function-claude-status-transformer.fn.crossplane.io/v1beta1",
+			"kind": "StatusTransformation",
+			"additionalContext": "",
+			"thinking": {"budgetTokens": 1024}
+		}`),
+		Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: xr}},
+	}
+	fp := func(req *fnv1.RunFunctionRequest) string {
+		s, err := fingerprint(req)
+		if err != nil {
+			t.Fatalf("fingerprint(...): unexpected error: %v", err)
+		}
+		return s
+	}
+
+	// analyzed returns the XR as it would be observed after Claude analyzed
+	// it, with the supplied fingerprint.
+	analyzed := func(fingerprint string) *structpb.Struct {
+		return resource.MustStructJSON(fmt.Sprintf(`{
+			"apiVersion": "example.org/v1",
+			"kind": "XR",
+			"metadata": {"name": "cool-xr"},
+			"status": {"conditions": [{
+				"type": "HealthyAccordingToClaude",
+				"status": "True",
+				"reason": "[]",
+				"message": "No unhealthy resources found",
+				"lastTransitionTime": "2025-06-04T16:00:00Z"
+			}, {
+				"type": "ClaudeObservedFingerprint",
+				"status": "True",
+				"reason": "Analyzed",
+				"message": %q,
+				"lastTransitionTime": "2025-06-04T16:00:00Z"
+			}]}
+		}`, fingerprint))
+	}
+
+	healthyXR := &fnv1.RunFunctionRequest{
+		Input: input,
+		Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(`{
+			"apiVersion": "example.org/v1",
+			"kind": "XR",
+			"metadata": {"name": "cool-xr"},
+			"status": {"conditions": [
+				{"type": "Ready", "status": "True", "reason": "Available"},
+				{"type": "Synced", "status": "True", "reason": "ReconcileSuccess"}
+			]}
+		}`)}},
+	}
+
+	analyzeHealthy := &fnv1.RunFunctionRequest{
+		Input: resource.MustStructJSON(`{
+			"apiVersion": "function-claude-status-transformer.fn.crossplane.io/v1beta1",
+			"kind": "StatusTransformation",
+			"additionalContext": "",
+			"analyzeHealthy": true
+		}`),
+		Observed: healthyXR.GetObserved(),
+	}
+
+	valid := `{"resourceStatuses":[{"name":"cool-db","kind":"RDSInstance","apiVersion":"rds.aws.upbound.io/v1beta1","ready":false,"message":"Subnet not found"}],"overallStatus":"NotReady","summary":"cool-db can't find its subnet"}`
+	invalid := `{"resourceStatuses":[],"overallStatus":"Broken"}`
+	reason := `[{"name":"cool-db","kind":"RDSInstance","apiVersion":"rds.aws.upbound.io/v1beta1","ready":false,"message":"Subnet not found"}]`
 
 	type args struct {
-		ctx context.Context
-		req *fnv1.RunFunctionRequest
+		ctx       context.Context
+		req       *fnv1.RunFunctionRequest
+		responses []string
 	}
 	type want struct {
 		rsp *fnv1.RunFunctionResponse
 		err error
+
+		// calls is the number of times Claude should be called.
+		calls int
+		// toolErrors is the number of is_error tool results that should
+		// be sent to Claude.
+		toolErrors int
 	}
 
 	cases := map[string]struct {
@@ -44,12 +252,337 @@ func TestRunFunction(t *testing.T) {
 		args   args
 		want   want
 	}{
-		// TODO(RedbackThomson): Add tests!
+		"ValidStatus": {
+			reason: "A valid status submitted on the first attempt should produce a condition and result.",
+			args: args{
+				ctx:       context.Background(),
+				req:       xrOnly,
+				responses: []string{toolUse("1", submitStatusToolName, valid)},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Ttl: durationpb.New(response.DefaultTTL)},
+					Conditions: []*fnv1.Condition{{
+						Type:    string(conditionTypeClaudeHealthy),
+						Status:  fnv1.Status_STATUS_CONDITION_FALSE,
+						Reason:  reason,
+						Message: ptr.To("cool-db can't find its subnet"),
+					}, {
+						Type:    string(conditionTypeClaudeFingerprint),
+						Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+						Reason:  reasonAnalyzed,
+						Message: ptr.To(fp(xrOnly)),
+					}},
+					Results: []*fnv1.Result{{
+						Severity: fnv1.Severity_SEVERITY_NORMAL,
+						Message:  "cool-db can't find its subnet",
+						Reason:   ptr.To(reasonUnhealthy),
+					}},
+				},
+				calls: 1,
+			},
+		},
+		"InvalidThenValidStatus": {
+			reason: "An invalid status should be sent back to Claude as an error, and the next valid status should produce a condition and result.",
+			args: args{
+				ctx: context.Background(),
+				req: xrOnly,
+				responses: []string{
+					toolUse("1", submitStatusToolName, invalid),
+					toolUse("2", submitStatusToolName, valid),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Ttl: durationpb.New(response.DefaultTTL)},
+					Conditions: []*fnv1.Condition{{
+						Type:    string(conditionTypeClaudeHealthy),
+						Status:  fnv1.Status_STATUS_CONDITION_FALSE,
+						Reason:  reason,
+						Message: ptr.To("cool-db can't find its subnet"),
+					}, {
+						Type:    string(conditionTypeClaudeFingerprint),
+						Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+						Reason:  reasonAnalyzed,
+						Message: ptr.To(fp(xrOnly)),
+					}},
+					Results: []*fnv1.Result{{
+						Severity: fnv1.Severity_SEVERITY_NORMAL,
+						Message:  "cool-db can't find its subnet",
+						Reason:   ptr.To(reasonUnhealthy),
+					}},
+				},
+				calls:      2,
+				toolErrors: 1,
+			},
+		},
+		"GetResourceThenValidStatus": {
+			reason: "Claude should be able to fetch a composed resource before submitting a valid status.",
+			args: args{
+				ctx: context.Background(),
+				req: xrAndDB,
+				responses: []string{
+					toolUse("1", getResourceToolName, `{"resource":"db","paths":["status.conditions"]}`),
+					toolUse("2", submitStatusToolName, valid),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Ttl: durationpb.New(response.DefaultTTL)},
+					Conditions: []*fnv1.Condition{{
+						Type:    string(conditionTypeClaudeHealthy),
+						Status:  fnv1.Status_STATUS_CONDITION_FALSE,
+						Reason:  reason,
+						Message: ptr.To("cool-db can't find its subnet"),
+					}, {
+						Type:    string(conditionTypeClaudeFingerprint),
+						Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+						Reason:  reasonAnalyzed,
+						Message: ptr.To(fp(xrAndDB)),
+					}},
+					Results: []*fnv1.Result{{
+						Severity: fnv1.Severity_SEVERITY_NORMAL,
+						Message:  "cool-db can't find its subnet",
+						Reason:   ptr.To(reasonUnhealthy),
+					}},
+				},
+				calls: 2,
+			},
+		},
+		"TooManyInvalidStatuses": {
+			reason: "We should give up and return a warning if Claude keeps submitting invalid statuses.",
+			args: args{
+				ctx: context.Background(),
+				req: &fnv1.RunFunctionRequest{
+					Input:    input,
+					Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: xr}},
+				},
+				responses: []string{
+					toolUse("1", submitStatusToolName, invalid),
+					toolUse("2", submitStatusToolName, invalid),
+					toolUse("3", submitStatusToolName, invalid),
+					toolUse("4", submitStatusToolName, invalid),
+					toolUse("5", submitStatusToolName, invalid),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{{
+						Severity: fnv1.Severity_SEVERITY_WARNING,
+						Message:  fmt.Sprintf("Claude didn't submit a valid status within the maximum of %d tool round trips", defaultMaxToolRoundTrips),
+						Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+					}},
+				},
+				calls:      defaultMaxToolRoundTrips,
+				toolErrors: defaultMaxToolRoundTrips - 1,
+			},
+		},
+		"OutputTokenLimitReached": {
+			reason: "We should give up, return a warning, and keep the last status if Claude generates too many tokens.",
+			args: args{
+				ctx: context.Background(),
+				req: &fnv1.RunFunctionRequest{
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "function-claude-status-transformer.fn.crossplane.io/v1beta1",
+						"kind": "StatusTransformation",
+						"additionalContext": "",
+						"limits": {"maxOutputTokens": 50}
+					}`),
+					Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(`{
+						"apiVersion": "example.org/v1",
+						"kind": "XR",
+						"metadata": {"name": "cool-xr"},
+						"status": {"conditions": [{
+							"type": "HealthyAccordingToClaude",
+							"status": "True",
+							"reason": "[]",
+							"message": "No unhealthy resources found",
+							"lastTransitionTime": "2025-06-04T16:00:00Z"
+						}]}
+					}`)}},
+				},
+				responses: []string{toolUse("1", submitStatusToolName, invalid)},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Ttl: durationpb.New(response.DefaultTTL)},
+					Conditions: []*fnv1.Condition{{
+						Type:    string(conditionTypeClaudeHealthy),
+						Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+						Reason:  "[]",
+						Message: ptr.To("No unhealthy resources found"),
+					}},
+					Results: []*fnv1.Result{{
+						Severity: fnv1.Severity_SEVERITY_WARNING,
+						Message:  "Claude didn't submit a valid status before generating the maximum of 50 output tokens",
+						Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+					}},
+				},
+				calls: 1,
+			},
+		},
+		"UnchangedFingerprint": {
+			reason: "We shouldn't call Claude, and should keep the last status, if the observed state is unchanged since Claude last analyzed it.",
+			args: args{
+				ctx: context.Background(),
+				req: &fnv1.RunFunctionRequest{
+					Input:    input,
+					Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: analyzed(fp(xrOnly))}},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Ttl: durationpb.New(response.DefaultTTL)},
+					Conditions: []*fnv1.Condition{{
+						Type:    string(conditionTypeClaudeHealthy),
+						Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+						Reason:  "[]",
+						Message: ptr.To("No unhealthy resources found"),
+					}, {
+						Type:    string(conditionTypeClaudeFingerprint),
+						Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+						Reason:  reasonAnalyzed,
+						Message: ptr.To(fp(xrOnly)),
+					}},
+				},
+				calls: 0,
+			},
+		},
+		"ChangedFingerprint": {
+			reason: "We should call Claude if the observed state changed since Claude last analyzed it.",
+			args: args{
+				ctx: context.Background(),
+				req: &fnv1.RunFunctionRequest{
+					Input:    input,
+					Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: analyzed("stale")}},
+				},
+				responses: []string{toolUse("1", submitStatusToolName, valid)},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Ttl: durationpb.New(response.DefaultTTL)},
+					Conditions: []*fnv1.Condition{{
+						Type:    string(conditionTypeClaudeHealthy),
+						Status:  fnv1.Status_STATUS_CONDITION_FALSE,
+						Reason:  reason,
+						Message: ptr.To("cool-db can't find its subnet"),
+					}, {
+						Type:    string(conditionTypeClaudeFingerprint),
+						Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+						Reason:  reasonAnalyzed,
+						Message: ptr.To(fp(xrOnly)),
+					}},
+					Results: []*fnv1.Result{{
+						Severity: fnv1.Severity_SEVERITY_NORMAL,
+						Message:  "cool-db can't find its subnet",
+						Reason:   ptr.To(reasonUnhealthy),
+					}},
+				},
+				calls: 1,
+			},
+		},
+		"AllHealthy": {
+			reason: "We shouldn't call Claude if the composite and every composed resource are Ready and Synced.",
+			args: args{
+				ctx: context.Background(),
+				req: healthyXR,
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Ttl: durationpb.New(response.DefaultTTL)},
+					Conditions: []*fnv1.Condition{{
+						Type:    string(conditionTypeClaudeHealthy),
+						Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+						Reason:  "[]",
+						Message: ptr.To("No unhealthy resources found"),
+					}, {
+						Type:    string(conditionTypeClaudeFingerprint),
+						Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+						Reason:  reasonAnalyzed,
+						Message: ptr.To(fp(healthyXR)),
+					}},
+					Results: []*fnv1.Result{{
+						Severity: fnv1.Severity_SEVERITY_NORMAL,
+						Message:  "No unhealthy resources found",
+						Reason:   ptr.To(reasonHealthy),
+					}},
+				},
+				calls: 0,
+			},
+		},
+		"AnalyzeHealthy": {
+			reason: "We should call Claude if the composition is healthy but analyzeHealthy is set.",
+			args: args{
+				ctx:       context.Background(),
+				req:       analyzeHealthy,
+				responses: []string{toolUse("1", submitStatusToolName, `{"resourceStatuses":[],"overallStatus":"Ready","summary":"No unhealthy resources found"}`)},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Ttl: durationpb.New(response.DefaultTTL)},
+					Conditions: []*fnv1.Condition{{
+						Type:    string(conditionTypeClaudeHealthy),
+						Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+						Reason:  "[]",
+						Message: ptr.To("No unhealthy resources found"),
+					}, {
+						Type:    string(conditionTypeClaudeFingerprint),
+						Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+						Reason:  reasonAnalyzed,
+						Message: ptr.To(fp(analyzeHealthy)),
+					}},
+					Results: []*fnv1.Result{{
+						Severity: fnv1.Severity_SEVERITY_NORMAL,
+						Message:  "No unhealthy resources found",
+						Reason:   ptr.To(reasonHealthy),
+					}},
+				},
+				calls: 1,
+			},
+		},
+		"ThinkingThenValidStatus": {
+			reason: "When thinking, Claude should be reminded to submit a status if it ends its turn without calling a tool.",
+			args: args{
+				ctx: context.Background(),
+				req: thinking,
+				responses: []string{
+					`{"id":"msg-1","type":"message","role":"assistant","model":"claude","stop_reason":"end_turn","usage":{"input_tokens":100,"output_tokens":50},"content":[{"type":"thinking","thinking":"The subnet is missing.","signature":"sig"},{"type":"text","text":"cool-db can't find its subnet."}]}`,
+					toolUse("2", submitStatusToolName, valid),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Ttl: durationpb.New(response.DefaultTTL)},
+					Conditions: []*fnv1.Condition{{
+						Type:    string(conditionTypeClaudeHealthy),
+						Status:  fnv1.Status_STATUS_CONDITION_FALSE,
+						Reason:  reason,
+						Message: ptr.To("cool-db can't find its subnet"),
+					}, {
+						Type:    string(conditionTypeClaudeFingerprint),
+						Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+						Reason:  reasonAnalyzed,
+						Message: ptr.To(fp(thinking)),
+					}},
+					Results: []*fnv1.Result{{
+						Severity: fnv1.Severity_SEVERITY_NORMAL,
+						Message:  "cool-db can't find its subnet",
+						Reason:   ptr.To(reasonUnhealthy),
+					}},
+				},
+				calls: 2,
+			},
+		},
 	}
 
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
-			f := &Function{log: logging.NewNopLogger()}
+			c := &fakeMessageClient{responses: tc.args.responses}
+			f := NewFunction(logging.NewNopLogger())
+			f.newClient = func(_ context.Context, _ *v1beta1.StatusTransformation, _ *fnv1.RunFunctionRequest) (MessageClient, error) {
+				return c, nil
+			}
 			rsp, err := f.RunFunction(tc.args.ctx, tc.args.req)
 
 			if diff := cmp.Diff(tc.want.rsp, rsp, protocmp.Transform()); diff != "" {
@@ -59,6 +592,2479 @@ func TestRunFunction(t *testing.T) {
 			if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
 				t.Errorf("%s\nf.RunFunction(...): -want err, +got err:\n%s", tc.reason, diff)
 			}
+
+			if diff := cmp.Diff(tc.want.calls, len(c.calls)); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want calls, +got calls:\n%s", tc.reason, diff)
+			}
+
+			toolErrors := 0
+			for _, call := range c.calls {
+				// Thinking isn't compatible with forced tool use.
+				if call.Thinking.OfEnabled == nil && call.ToolChoice.OfAny == nil {
+					t.Errorf("%s\nf.RunFunction(...): want tool_choice forced to any tool", tc.reason)
+				}
+			}
+			if len(c.calls) > 0 {
+				for _, m := range c.calls[len(c.calls)-1].Messages {
+					for _, b := range m.Content {
+						if b.OfToolResult != nil && b.OfToolResult.IsError.Value {
+							toolErrors++
+						}
+					}
+				}
+			}
+			if diff := cmp.Diff(tc.want.toolErrors, toolErrors); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want tool errors, +got tool errors:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	type want struct {
+		status CompositionStatus
+		err    bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		input  string
+		want   want
+	}{
+		"InvalidJSON": {
+			reason: "We should return an error if the input isn't JSON.",
+			input:  `{"overallStatus": `,
+			want: want{
+				err: true,
+			},
+		},
+		"MissingRequiredField": {
+			reason: "We should return an error if a required field is missing.",
+			input:  `{"resourceStatuses": [], "overallStatus": "Ready"}`,
+			want: want{
+				err: true,
+			},
+		},
+		"MissingRequiredResourceStatusField": {
+			reason: "We should return an error if a resource status is missing a required field.",
+			input:  `{"resourceStatuses": [{"name": "a", "kind": "B", "apiVersion": "c/v1", "message": "broken"}], "overallStatus": "NotReady", "summary": "broken"}`,
+			want: want{
+				err: true,
+			},
+		},
+		"UnknownField": {
+			reason: "We should return an error if the input contains fields that aren't in the schema.",
+			input:  `{"resourceStatuses": [], "overallStatus": "Ready", "summary": "No unhealthy resources found", "status_json": "{}"}`,
+			want: want{
+				err: true,
+			},
+		},
+		"InvalidOverallStatus": {
+			reason: "We should return an error if overallStatus isn't one of the allowed values.",
+			input:  `{"resourceStatuses": [], "overallStatus": "Healthy", "summary": "No unhealthy resources found"}`,
+			want: want{
+				err: true,
+			},
+		},
+		"ReadyWithUnreadyResource": {
+			reason: "We should return an error if the composition is ready but a resource isn't.",
+			input:  `{"resourceStatuses": [{"name": "a", "kind": "B", "apiVersion": "c/v1", "ready": false, "message": "broken"}], "overallStatus": "Ready", "summary": "broken"}`,
+			want: want{
+				err: true,
+			},
+		},
+		"InvalidCategory": {
+			reason: "We should return an error if a resource status's category isn't one of the allowed values.",
+			input:  `{"resourceStatuses": [{"name": "a", "kind": "B", "apiVersion": "c/v1", "ready": false, "message": "broken", "category": "Broken"}], "overallStatus": "NotReady", "summary": "a is broken"}`,
+			want: want{
+				err: true,
+			},
+		},
+		"InvalidDocsURL": {
+			reason: "We should return an error if a resource status's docsURL isn't an http or https URL.",
+			input:  `{"resourceStatuses": [{"name": "a", "kind": "B", "apiVersion": "c/v1", "ready": false, "message": "broken", "docsURL": "see the docs"}], "overallStatus": "NotReady", "summary": "a is broken"}`,
+			want: want{
+				err: true,
+			},
+		},
+		"RootCauseCausedBy": {
+			reason: "We should return an error if a root cause is caused by another resource.",
+			input:  `{"resourceStatuses": [{"name": "a", "kind": "B", "apiVersion": "c/v1", "ready": false, "message": "broken", "rootCause": true, "causedBy": "d"}], "overallStatus": "NotReady", "summary": "a is broken"}`,
+			want: want{
+				err: true,
+			},
+		},
+		"ValidWithCategory": {
+			reason: "We should return the parsed categories if the input is valid.",
+			input:  `{"resourceStatuses": [{"name": "a", "kind": "B", "apiVersion": "c/v1", "ready": false, "message": "broken", "category": "Quota"}], "overallStatus": "NotReady", "summary": "a is broken", "category": "Quota"}`,
+			want: want{
+				status: CompositionStatus{
+					ResourceStatuses: []composedResourceStatus{{
+						Name:       "a",
+						Kind:       "B",
+						APIVersion: "c/v1",
+						Ready:      false,
+						Message:    "broken",
+						Category:   categoryQuota,
+					}},
+					OverallStatus: overallStatusNotReady,
+					Summary:       "a is broken",
+					Category:      categoryQuota,
+				},
+			},
+		},
+		"Valid": {
+			reason: "We should return the parsed status if the input is valid.",
+			input:  `{"resourceStatuses": [{"name": "a", "kind": "B", "apiVersion": "c/v1", "ready": false, "message": "broken"}], "overallStatus": "NotReady", "summary": "a is broken"}`,
+			want: want{
+				status: CompositionStatus{
+					ResourceStatuses: []composedResourceStatus{{
+						Name:       "a",
+						Kind:       "B",
+						APIVersion: "c/v1",
+						Ready:      false,
+						Message:    "broken",
+					}},
+					OverallStatus: overallStatusNotReady,
+					Summary:       "a is broken",
+				},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			status, err := parseStatus(tc.input)
+			if tc.want.err {
+				if err == nil {
+					t.Errorf("%s\nparseStatus(...): want error, got nil", tc.reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("%s\nparseStatus(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.status, status); diff != "" {
+				t.Errorf("%s\nparseStatus(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestGetResource(t *testing.T) {
+	r := observedResources{
+		"db": `{"apiVersion":"rds.aws.upbound.io/v1beta1","kind":"RDSInstance","metadata":{"name":"cool-db"},"spec":{"forProvider":{"engine":"mysql"}}}`,
+	}
+
+	type want struct {
+		result string
+		err    bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		input  string
+		want   want
+	}{
+		"UnknownResource": {
+			reason: "We should return an error if the requested resource doesn't exist.",
+			input:  `{"resource":"cache"}`,
+			want: want{
+				err: true,
+			},
+		},
+		"WholeResource": {
+			reason: "We should return the whole resource if no paths are requested.",
+			input:  `{"resource":"db"}`,
+			want: want{
+				result: r["db"],
+			},
+		},
+		"SelectedPaths": {
+			reason: "We should return only the requested paths, and null for paths that don't exist.",
+			input:  `{"resource":"db","paths":["spec.forProvider.engine","status.conditions"]}`,
+			want: want{
+				result: `{"spec.forProvider.engine":"mysql","status.conditions":null}`,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			result, err := r.GetResource(tc.input)
+			if tc.want.err {
+				if err == nil {
+					t.Errorf("%s\nr.GetResource(...): want error, got nil", tc.reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("%s\nr.GetResource(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.result, result); diff != "" {
+				t.Errorf("%s\nr.GetResource(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestListEvents(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	objs := []client.Object{
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Namespace: "default", Name: "xr-event"},
+			InvolvedObject: corev1.ObjectReference{UID: "xr-uid"},
+			Reason:         "Composed",
+			LastTimestamp:  metav1.NewTime(base),
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Namespace: "default", Name: "other-event"},
+			InvolvedObject: corev1.ObjectReference{UID: "other-uid"},
+			Reason:         "Other",
+			LastTimestamp:  metav1.NewTime(base),
+		},
+	}
+	for i := range maxEvents + 2 {
+		objs = append(objs, &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Namespace: "default", Name: fmt.Sprintf("db-event-%02d", i)},
+			InvolvedObject: corev1.ObjectReference{UID: "db-uid"},
+			Reason:         fmt.Sprintf("Event%02d", i),
+			LastTimestamp:  metav1.NewTime(base.Add(time.Duration(i) * time.Minute)),
+		})
+	}
+
+	c := fake.NewClientBuilder().
+		WithObjects(objs...).
+		WithIndex(&corev1.Event{}, eventInvolvedObjectUIDField, IndexEventByInvolvedObjectUID).
+		Build()
+
+	l := &eventLister{
+		c:         c,
+		composite: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr","uid":"xr-uid"}}`,
+		observed: observedResources{
+			"db":    `{"apiVersion":"rds.aws.upbound.io/v1beta1","kind":"RDSInstance","metadata":{"name":"cool-db","uid":"db-uid"}}`,
+			"cache": `{"apiVersion":"elasticache.aws.upbound.io/v1beta1","kind":"Cluster","metadata":{"name":"cool-cache"}}`,
+		},
+	}
+
+	newest := []string{}
+	for i := maxEvents + 1; i > 1; i-- {
+		newest = append(newest, fmt.Sprintf("Event%02d", i))
+	}
+
+	type want struct {
+		reasons []string
+		err     bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		input  string
+		want   want
+	}{
+		"Composite": {
+			reason: "We should list the events of the composite resource if no resource is requested.",
+			input:  `{}`,
+			want: want{
+				reasons: []string{"Composed"},
+			},
+		},
+		"ComposedNewestFirst": {
+			reason: "We should list only the events involving the requested resource, newest first, truncated to the maximum.",
+			input:  `{"resource":"db"}`,
+			want: want{
+				reasons: newest,
+			},
+		},
+		"UnknownResource": {
+			reason: "We should return an error if the requested resource doesn't exist.",
+			input:  `{"resource":"queue"}`,
+			want: want{
+				err: true,
+			},
+		},
+		"NoUID": {
+			reason: "We should return an error if the requested resource has no UID to select its events by.",
+			input:  `{"resource":"cache"}`,
+			want: want{
+				err: true,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			result, err := l.ListEvents(context.Background(), tc.input)
+			if tc.want.err {
+				if err == nil {
+					t.Errorf("%s\nl.ListEvents(...): want error, got nil", tc.reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s\nl.ListEvents(...): unexpected error: %v", tc.reason, err)
+			}
+
+			events := []event{}
+			if err := json.Unmarshal([]byte(result), &events); err != nil {
+				t.Fatalf("%s\nl.ListEvents(...): cannot unmarshal result: %v", tc.reason, err)
+			}
+			reasons := make([]string, 0, len(events))
+			for _, e := range events {
+				reasons = append(reasons, e.Reason)
+			}
+			if diff := cmp.Diff(tc.want.reasons, reasons); diff != "" {
+				t.Errorf("%s\nl.ListEvents(...): -want event reasons, +got event reasons:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestRunFunctionListEvents(t *testing.T) {
+	req := &fnv1.RunFunctionRequest{
+		Input: resource.MustStructJSON(`{
+			"apiVersion": "function-claude-status-transformer.fn.crossplane.io/v1beta1",
+			"kind": "StatusTransformation",
+			"additionalContext": ""
+		}`),
+		Observed: &fnv1.State{
+			Composite: &fnv1.Resource{Resource: resource.MustStructJSON(`{
+				"apiVersion": "example.org/v1",
+				"kind": "XR",
+				"metadata": {"name": "cool-xr", "uid": "xr-uid"}
+			}`)},
+			Resources: map[string]*fnv1.Resource{
+				"db": {Resource: resource.MustStructJSON(`{
+					"apiVersion": "rds.aws.upbound.io/v1beta1",
+					"kind": "RDSInstance",
+					"metadata": {"name": "cool-db", "uid": "db-uid"},
+					"status": {"conditions": [{"type": "Ready", "status": "False", "reason": "ReconcileError"}]}
+				}`)},
+			},
+		},
+	}
+	responses := []string{
+		toolUse("1", listEventsToolName, `{"resource":"db"}`),
+		toolUse("2", submitStatusToolName, `{"resourceStatuses":[],"overallStatus":"NotReady","summary":"cool-db is failing"}`),
+	}
+
+	c := fake.NewClientBuilder().
+		WithIndex(&corev1.Event{}, eventInvolvedObjectUIDField, IndexEventByInvolvedObjectUID).
+		Build()
+
+	type want struct {
+		tools   []string
+		calls   int
+		warning bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		opts   []Option
+		want   want
+	}{
+		"Disabled": {
+			reason: "Claude shouldn't be offered the list_events tool unless it's enabled, and we should give up if it uses it anyway.",
+			opts:   []Option{WithClient(c)},
+			want: want{
+				tools:   []string{getResourceToolName, submitStatusToolName},
+				calls:   1,
+				warning: true,
+			},
+		},
+		"EnabledWithoutClient": {
+			reason: "Claude shouldn't be offered the list_events tool if there's no client to list events with.",
+			opts:   []Option{WithListEventsTool()},
+			want: want{
+				tools:   []string{getResourceToolName, submitStatusToolName},
+				calls:   1,
+				warning: true,
+			},
+		},
+		"Enabled": {
+			reason: "Claude should be offered the list_events tool when it's enabled.",
+			opts:   []Option{WithClient(c), WithListEventsTool()},
+			want: want{
+				tools: []string{getResourceToolName, listEventsToolName, submitStatusToolName},
+				calls: 2,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			mc := &fakeMessageClient{responses: responses}
+			f := NewFunction(logging.NewNopLogger(), tc.opts...)
+			f.newClient = func(_ context.Context, _ *v1beta1.StatusTransformation, _ *fnv1.RunFunctionRequest) (MessageClient, error) {
+				return mc, nil
+			}
+
+			rsp, err := f.RunFunction(context.Background(), req)
+			if err != nil {
+				t.Fatalf("%s\nf.RunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.calls, len(mc.calls)); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want calls, +got calls:\n%s", tc.reason, diff)
+			}
+
+			tools := []string{}
+			for _, tool := range mc.calls[0].Tools {
+				if tool.OfTool != nil {
+					tools = append(tools, tool.OfTool.Name)
+				}
+			}
+			if diff := cmp.Diff(tc.want.tools, tools, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want tools, +got tools:\n%s", tc.reason, diff)
+			}
+
+			warning := false
+			for _, r := range rsp.GetResults() {
+				if r.GetSeverity() == fnv1.Severity_SEVERITY_WARNING {
+					warning = true
+				}
+			}
+			if diff := cmp.Diff(tc.want.warning, warning); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want warning, +got warning:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestBuildPrompt(t *testing.T) {
+	v := &Variables{Input: "Be brief."}
+
+	type args struct {
+		in *v1beta1.StatusTransformation
+		fc *v1alpha1.FunctionConfig
+	}
+	type want struct {
+		prompt Prompt
+		err    bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"Default": {
+			reason: "We should use the default prompt if it isn't customized.",
+			args: args{
+				in: &v1beta1.StatusTransformation{},
+			},
+			want: want{
+				prompt: Prompt{System: system, Instructions: prompt},
+			},
+		},
+		"AppendAndReplace": {
+			reason: "We should append or replace the default prompt according to each template's mode, rendering the variables.",
+			args: args{
+				in: &v1beta1.StatusTransformation{
+					Prompt: &v1beta1.Prompt{
+						System:       &v1beta1.PromptTemplate{Template: "The platform team owns databases."},
+						Instructions: &v1beta1.PromptTemplate{Mode: v1beta1.PromptModeReplace, Template: "{{ .Input }}"},
+					},
+				},
+			},
+			want: want{
+				prompt: Prompt{System: system + "\nThe platform team owns databases.", Instructions: "Be brief."},
+			},
+		},
+		"InputOverridesFunctionConfig": {
+			reason: "Templates set in the input should take precedence over those in the FunctionConfig.",
+			args: args{
+				in: &v1beta1.StatusTransformation{
+					Prompt: &v1beta1.Prompt{
+						System: &v1beta1.PromptTemplate{Mode: v1beta1.PromptModeReplace, Template: "From the input."},
+					},
+				},
+				fc: &v1alpha1.FunctionConfig{
+					Spec: v1alpha1.FunctionConfigSpec{
+						Prompt: &v1alpha1.Prompt{
+							System:       &v1alpha1.PromptTemplate{Mode: "Replace", Template: "From the FunctionConfig."},
+							Instructions: &v1alpha1.PromptTemplate{Mode: "Replace", Template: "Shared instructions."},
+						},
+					},
+				},
+			},
+			want: want{
+				prompt: Prompt{System: "From the input.", Instructions: "Shared instructions."},
+			},
+		},
+		"InvalidTemplate": {
+			reason: "We should return an error if a template can't be rendered.",
+			args: args{
+				in: &v1beta1.StatusTransformation{
+					Prompt: &v1beta1.Prompt{
+						System: &v1beta1.PromptTemplate{Template: "{{ .Missing }}"},
+					},
+				},
+			},
+			want: want{
+				err: true,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			p, err := buildPrompt(tc.args.in, tc.args.fc, v)
+			if tc.want.err {
+				if err == nil {
+					t.Errorf("%s\nbuildPrompt(...): want error, got nil", tc.reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("%s\nbuildPrompt(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.prompt, p); diff != "" {
+				t.Errorf("%s\nbuildPrompt(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestGetGenerationParams(t *testing.T) {
+	type want struct {
+		gp  generationParams
+		err bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		in     *v1beta1.StatusTransformation
+		want   want
+	}{
+		"Defaults": {
+			reason: "We should use the default model and parameters if none are configured.",
+			in:     &v1beta1.StatusTransformation{},
+			want: want{
+				gp: generationParams{model: defaultAnthropicModel, maxTokens: defaultMaxTokens, temperature: anthropic.Float(defaultTemperature)},
+			},
+		},
+		"Configured": {
+			reason: "We should use the configured model and parameters.",
+			in: &v1beta1.StatusTransformation{
+				Anthropic: &v1beta1.Anthropic{
+					Model:       string(anthropic.ModelClaude3_5HaikuLatest),
+					MaxTokens:   ptr.To[int64](2048),
+					Temperature: ptr.To(0.5),
+					TopK:        ptr.To[int64](10),
+				},
+			},
+			want: want{
+				gp: generationParams{model: anthropic.ModelClaude3_5HaikuLatest, maxTokens: 2048, temperature: anthropic.Float(0.5), topK: anthropic.Int(10)},
+			},
+		},
+		"UnsupportedModel": {
+			reason: "We should return an error if the model isn't in the capability table.",
+			in: &v1beta1.StatusTransformation{
+				Anthropic: &v1beta1.Anthropic{Model: "claude-unknown"},
+			},
+			want: want{
+				err: true,
+			},
+		},
+		"TooManyTokens": {
+			reason: "We should return an error if maxTokens exceeds what the model can generate.",
+			in: &v1beta1.StatusTransformation{
+				Anthropic: &v1beta1.Anthropic{
+					Model:     string(anthropic.ModelClaude3_5HaikuLatest),
+					MaxTokens: ptr.To[int64](10000),
+				},
+			},
+			want: want{
+				err: true,
+			},
+		},
+		"InvalidTemperature": {
+			reason: "We should return an error if temperature is out of range.",
+			in: &v1beta1.StatusTransformation{
+				Anthropic: &v1beta1.Anthropic{Temperature: ptr.To(1.5)},
+			},
+			want: want{
+				err: true,
+			},
+		},
+		"Thinking": {
+			reason: "We should enable thinking and omit the temperature, which thinking requires to be 1.",
+			in: &v1beta1.StatusTransformation{
+				Thinking: &v1beta1.Thinking{BudgetTokens: 2048},
+			},
+			want: want{
+				gp: generationParams{model: defaultAnthropicModel, maxTokens: defaultMaxTokens, thinkingBudget: 2048},
+			},
+		},
+		"ThinkingUnsupportedModel": {
+			reason: "We should return an error if thinking is enabled for a model that doesn't support it.",
+			in: &v1beta1.StatusTransformation{
+				Anthropic: &v1beta1.Anthropic{Model: string(anthropic.ModelClaude3_5HaikuLatest)},
+				Thinking:  &v1beta1.Thinking{BudgetTokens: 2048},
+			},
+			want: want{
+				err: true,
+			},
+		},
+		"ThinkingWithTemperature": {
+			reason: "We should return an error if thinking is enabled with a temperature other than 1.",
+			in: &v1beta1.StatusTransformation{
+				Anthropic: &v1beta1.Anthropic{Temperature: ptr.To(0.5)},
+				Thinking:  &v1beta1.Thinking{BudgetTokens: 2048},
+			},
+			want: want{
+				err: true,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			gp, err := getGenerationParams(tc.in)
+			if tc.want.err {
+				if err == nil {
+					t.Errorf("%s\ngetGenerationParams(...): want error, got nil", tc.reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("%s\ngetGenerationParams(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.gp, gp, cmp.AllowUnexported(generationParams{}, param.Opt[int64]{}, param.Opt[float64]{})); diff != "" {
+				t.Errorf("%s\ngetGenerationParams(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestGetExamples(t *testing.T) {
+	valid := `{"resourceStatuses":[],"overallStatus":"Ready","summary":"No unhealthy resources found"}`
+
+	type want struct {
+		examples []v1beta1.Example
+		err      bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		in     *v1beta1.StatusTransformation
+		want   want
+	}{
+		"NoExamples": {
+			reason: "We should return no examples if none are configured.",
+			in:     &v1beta1.StatusTransformation{},
+			want:   want{},
+		},
+		"ValidInlineExample": {
+			reason: "We should return valid inline examples.",
+			in: &v1beta1.StatusTransformation{
+				Examples: &v1beta1.Examples{
+					Items: []v1beta1.Example{{Resources: "db", Status: runtime.RawExtension{Raw: []byte(valid)}}},
+				},
+			},
+			want: want{
+				examples: []v1beta1.Example{{Resources: "db", Status: runtime.RawExtension{Raw: []byte(valid)}}},
+			},
+		},
+		"InvalidInlineExample": {
+			reason: "We should return an error if an example's status doesn't match the shape of CompositionStatus.",
+			in: &v1beta1.StatusTransformation{
+				Examples: &v1beta1.Examples{
+					Items: []v1beta1.Example{{Resources: "db", Status: runtime.RawExtension{Raw: []byte(`{"overallStatus":"Ready"}`)}}},
+				},
+			},
+			want: want{
+				err: true,
+			},
+		},
+		"ConfigMapWithoutClient": {
+			reason: "We should return an error if examples are referenced from a ConfigMap but the Function has no client.",
+			in: &v1beta1.StatusTransformation{
+				Examples: &v1beta1.Examples{
+					ConfigMapReference: &v1beta1.NamespacedReference{Name: "examples", Namespace: "default"},
+				},
+			},
+			want: want{
+				err: true,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := NewFunction(logging.NewNopLogger())
+			examples, err := f.getExamples(context.Background(), tc.in)
+			if tc.want.err {
+				if err == nil {
+					t.Errorf("%s\nf.getExamples(...): want error, got nil", tc.reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("%s\nf.getExamples(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.examples, examples, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("%s\nf.getExamples(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+// fakeBatchClient serves a single message batch, which ends once it has been
+// polled.
+type fakeBatchClient struct {
+	// results are the JSON lines of the batch's results.
+	results string
+
+	submitted []anthropic.MessageBatchNewParams
+	polled    int
+}
+
+func (c *fakeBatchClient) New(_ context.Context, body anthropic.MessageBatchNewParams, _ ...option.RequestOption) (*anthropic.MessageBatch, error) {
+	c.submitted = append(c.submitted, body)
+	return &anthropic.MessageBatch{ID: "batch-1", ProcessingStatus: anthropic.MessageBatchProcessingStatusInProgress}, nil
+}
+
+func (c *fakeBatchClient) Get(_ context.Context, _ string, _ ...option.RequestOption) (*anthropic.MessageBatch, error) {
+	c.polled++
+	return &anthropic.MessageBatch{ID: "batch-1", ProcessingStatus: anthropic.MessageBatchProcessingStatusEnded}, nil
+}
+
+func (c *fakeBatchClient) ResultsStreaming(_ context.Context, _ string, _ ...option.RequestOption) *jsonl.Stream[anthropic.MessageBatchIndividualResponse] {
+	return jsonl.NewStream[anthropic.MessageBatchIndividualResponse](&http.Response{Body: io.NopCloser(strings.NewReader(c.results))}, nil)
+}
+
+func TestRunFunctionBatch(t *testing.T) {
+	input := resource.MustStructJSON(`{
+		"apiVersion": "function-claude-status-transformer.fn.crossplane.io/v1beta1",
+		"kind": "StatusTransformation",
+		"additionalContext": "",
+		"mode": "Batch"
+	}`)
+	xr := resource.MustStructJSON(`{
+		"apiVersion": "example.org/v1",
+		"kind": "XR",
+		"metadata": {"name": "cool-xr", "uid": "cool-uid"}
+	}`)
+
+	valid := `{"resourceStatuses":[],"overallStatus":"Ready","summary":"No unhealthy resources found"}`
+	result := fmt.Sprintf(`{"custom_id":"cool-uid","result":{"type":"succeeded","message":%s}}`, toolUse("1", submitStatusToolName, valid))
+
+	c := &fakeBatchClient{results: result + "\n"}
+	f := NewFunction(logging.NewNopLogger())
+	f.newBatchClient = func(_ context.Context, _ *v1beta1.StatusTransformation, _ *fnv1.RunFunctionRequest) (BatchClient, error) {
+		return c, nil
+	}
+	req := &fnv1.RunFunctionRequest{
+		Input:    input,
+		Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: xr}},
+	}
+
+	// The first call should submit a batch and return without a status.
+	rsp, err := f.RunFunction(context.Background(), req)
+	if err != nil {
+		t.Fatalf("f.RunFunction(...): unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(0, len(rsp.GetConditions())); diff != "" {
+		t.Errorf("f.RunFunction(...): first call should not set a condition: -want, +got:\n%s", diff)
+	}
+	if diff := cmp.Diff(1, len(c.submitted)); diff != "" {
+		t.Errorf("f.RunFunction(...): first call should submit a batch: -want, +got:\n%s", diff)
+	}
+	if len(c.submitted) == 1 && c.submitted[0].Requests[0].Params.ToolChoice.OfTool == nil {
+		t.Errorf("f.RunFunction(...): want tool_choice forced to the %s tool", submitStatusToolName)
+	}
+
+	// The second call should collect the batch's result.
+	rsp, err = f.RunFunction(context.Background(), req)
+	if err != nil {
+		t.Fatalf("f.RunFunction(...): unexpected error: %v", err)
+	}
+	fp, err := fingerprint(req)
+	if err != nil {
+		t.Fatalf("fingerprint(...): unexpected error: %v", err)
+	}
+	want := []*fnv1.Condition{{
+		Type:    string(conditionTypeClaudeHealthy),
+		Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+		Reason:  "[]",
+		Message: ptr.To("No unhealthy resources found"),
+	}, {
+		Type:    string(conditionTypeClaudeFingerprint),
+		Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+		Reason:  reasonAnalyzed,
+		Message: ptr.To(fp),
+	}}
+	if diff := cmp.Diff(want, rsp.GetConditions(), protocmp.Transform()); diff != "" {
+		t.Errorf("f.RunFunction(...): second call should set the batch's status: -want, +got:\n%s", diff)
+	}
+	if diff := cmp.Diff(1, len(c.submitted)); diff != "" {
+		t.Errorf("f.RunFunction(...): second call should not submit another batch: -want, +got:\n%s", diff)
+	}
+}
+
+func TestPendingBatchesEvict(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := map[string]struct {
+		reason string
+		age    time.Duration
+		want   bool
+	}{
+		"Recent": {
+			reason: "A batch submitted recently should still be pending.",
+			age:    time.Hour,
+			want:   true,
+		},
+		"Old": {
+			reason: "A batch older than the maximum age should be forgotten, e.g. because its XR was deleted.",
+			age:    maxPendingBatchAge + time.Hour,
+			want:   false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			p := newPendingBatches()
+			p.now = func() time.Time { return now }
+			p.Set("cool-uid", pendingBatch{ID: "batch", SubmittedAt: now.Add(-tc.age)})
+
+			_, got := p.Get("cool-uid")
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\np.Get(...): -want pending, +got pending:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestPromptResourcesTrim(t *testing.T) {
+	healthy := `{"kind":"Bucket","metadata":{"name":"cool-bucket","managedFields":[{"manager":"crossplane"}]},"status":{"conditions":[{"type":"Ready","status":"True"},{"type":"Synced","status":"True"}]}}`
+	unhealthy := `{"kind":"RDSInstance","metadata":{"name":"cool-db","annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{}"}},"status":{"conditions":[{"type":"Ready","status":"False","reason":"ReconcileError"}]}}`
+	xr := `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr","managedFields":[{"manager":"crossplane"}]},"spec":{"size":"large"}}`
+
+	r := promptResources{
+		composite:         `{"resource":` + xr + `}`,
+		compositeResource: xr,
+		observed:          observedResources{"bucket": healthy, "db": unhealthy},
+	}
+
+	type want struct {
+		composite string
+		observed  observedResources
+	}
+
+	cases := map[string]struct {
+		reason string
+		level  trimLevel
+		want   want
+	}{
+		"None": {
+			reason: "We should send the resources as observed if they aren't trimmed.",
+			level:  trimNone,
+			want: want{
+				composite: r.composite,
+				observed:  r.observed,
+			},
+		},
+		"Noise": {
+			reason: "We should drop managedFields and the last-applied-configuration annotation.",
+			level:  trimNoise,
+			want: want{
+				composite: `{"apiVersion":"example.org/v1","kind":"XR","metadata":{"name":"cool-xr"},"spec":{"size":"large"}}`,
+				observed: observedResources{
+					"bucket": `{"kind":"Bucket","metadata":{"name":"cool-bucket"},"status":{"conditions":[{"status":"True","type":"Ready"},{"status":"True","type":"Synced"}]}}`,
+					"db":     `{"kind":"RDSInstance","metadata":{"name":"cool-db"},"status":{"conditions":[{"reason":"ReconcileError","status":"False","type":"Ready"}]}}`,
+				},
+			},
+		},
+		"Composite": {
+			reason: "We should reduce the composite resource to its identity and conditions.",
+			level:  trimComposite,
+			want: want{
+				composite: `{"apiVersion":"example.org/v1","kind":"XR","metadata.name":"cool-xr"}`,
+				observed: observedResources{
+					"bucket": `{"kind":"Bucket","metadata":{"name":"cool-bucket"},"status":{"conditions":[{"status":"True","type":"Ready"},{"status":"True","type":"Synced"}]}}`,
+					"db":     `{"kind":"RDSInstance","metadata":{"name":"cool-db"},"status":{"conditions":[{"reason":"ReconcileError","status":"False","type":"Ready"}]}}`,
+				},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			composite, observed, _ := r.Trim(tc.level)
+			if diff := cmp.Diff(tc.want.composite, composite); diff != "" {
+				t.Errorf("%s\nr.Trim(...): -want composite, +got composite:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.observed, observed); diff != "" {
+				t.Errorf("%s\nr.Trim(...): -want observed, +got observed:\n%s", tc.reason, diff)
+			}
+		})
+	}
+
+	// Healthy resources should be omitted from the description of the
+	// composed resources, but unhealthy resources should be kept.
+	_, _, composed := r.Trim(trimHealthy)
+	if strings.Contains(composed, "cool-bucket") || !strings.Contains(composed, "cool-db") {
+		t.Errorf("r.Trim(trimHealthy): want only unhealthy resources described, got:\n%s", composed)
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	cases := map[string]struct {
+		reason   string
+		manifest string
+		want     bool
+	}{
+		"ReadyAndSynced": {
+			reason:   "A resource that's Ready and Synced should be healthy.",
+			manifest: `{"status":{"conditions":[{"type":"Ready","status":"True"},{"type":"Synced","status":"True"}]}}`,
+			want:     true,
+		},
+		"NotSynced": {
+			reason:   "A resource that isn't Synced shouldn't be healthy.",
+			manifest: `{"status":{"conditions":[{"type":"Ready","status":"True"},{"type":"Synced","status":"False"}]}}`,
+			want:     false,
+		},
+		"SyncedUnknown": {
+			reason:   "A resource whose Synced condition is Unknown shouldn't be healthy.",
+			manifest: `{"status":{"conditions":[{"type":"Ready","status":"True"},{"type":"Synced","status":"Unknown"}]}}`,
+			want:     false,
+		},
+		"SyncedMissing": {
+			reason:   "A resource without a Synced condition shouldn't be healthy.",
+			manifest: `{"status":{"conditions":[{"type":"Ready","status":"True"}]}}`,
+			want:     false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, healthy(tc.manifest)); diff != "" {
+				t.Errorf("%s\nhealthy(...): -want, +got:\n%s", tc.reason, diff)
+			}
+
+			// Trimming and the healthy fast path must agree.
+			trimmed := len(observedResources{"r": tc.manifest}.unhealthy()) == 0
+			if diff := cmp.Diff(tc.want, trimmed); diff != "" {
+				t.Errorf("%s\nr.unhealthy(): -want trimmed as healthy, +got trimmed as healthy:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	input := resource.MustStructJSON(`{
+		"apiVersion": "function-claude-status-transformer.fn.crossplane.io/v1beta1",
+		"kind": "StatusTransformation",
+		"additionalContext": ""
+	}`)
+
+	// req returns a request for an XR whose database fails to find its
+	// subnet. Only the names, UIDs, and timestamps differ between XRs.
+	req := func(xr, db, uid, created string) *fnv1.RunFunctionRequest {
+		return &fnv1.RunFunctionRequest{
+			Input: input,
+			Observed: &fnv1.State{
+				Composite: &fnv1.Resource{Resource: resource.MustStructJSON(fmt.Sprintf(`{
+					"apiVersion": "example.org/v1",
+					"kind": "XR",
+					"metadata": {"name": %q, "uid": %q, "creationTimestamp": %q}
+				}`, xr, uid, created))},
+				Resources: map[string]*fnv1.Resource{
+					"db": {Resource: resource.MustStructJSON(fmt.Sprintf(`{
+						"apiVersion": "rds.aws.upbound.io/v1beta1",
+						"kind": "RDSInstance",
+						"metadata": {"name": %q, "ownerReferences": [{"uid": %q}]},
+						"status": {"conditions": [{
+							"type": "Ready",
+							"status": "False",
+							"reason": "ReconcileError",
+							"message": "Subnet not found",
+							"lastTransitionTime": %q
+						}]}
+					}`, db, uid, created))},
+				},
+			},
+		}
+	}
+
+	valid := `{"resourceStatuses":[{"name":"cool-xr-abcde","kind":"RDSInstance","apiVersion":"rds.aws.upbound.io/v1beta1","ready":false,"message":"Subnet not found"}],"overallStatus":"NotReady","summary":"cool-xr-abcde can't find its subnet"}`
+
+	c := &fakeMessageClient{responses: []string{toolUse("1", submitStatusToolName, valid)}}
+	f := NewFunction(logging.NewNopLogger(), WithResponseCache(NewMemoryCacheBackend(10), time.Minute, true))
+	f.newClient = func(_ context.Context, _ *v1beta1.StatusTransformation, _ *fnv1.RunFunctionRequest) (MessageClient, error) {
+		return c, nil
+	}
+
+	if _, err := f.RunFunction(context.Background(), req("cool-xr", "cool-xr-abcde", "cool-uid", "2025-06-04T16:00:00Z")); err != nil {
+		t.Fatalf("f.RunFunction(...): unexpected error: %v", err)
+	}
+
+	// The second XR fails for the same reason, so it should reuse the first
+	// XR's diagnosis, with its own names.
+	rsp, err := f.RunFunction(context.Background(), req("other-xr", "other-xr-fghij", "other-uid", "2025-06-05T09:30:00Z"))
+	if err != nil {
+		t.Fatalf("f.RunFunction(...): unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(1, len(c.calls)); diff != "" {
+		t.Errorf("f.RunFunction(...): second XR should reuse the cached diagnosis: -want calls, +got calls:\n%s", diff)
+	}
+	reason := `[{"name":"other-xr-fghij","kind":"RDSInstance","apiVersion":"rds.aws.upbound.io/v1beta1","ready":false,"message":"Subnet not found"}]`
+	got := ""
+	for _, cond := range rsp.GetConditions() {
+		if cond.GetType() == string(conditionTypeClaudeHealthy) {
+			got = cond.GetReason()
+		}
+	}
+	if diff := cmp.Diff(reason, got); diff != "" {
+		t.Errorf("f.RunFunction(...): -want condition reason, +got condition reason:\n%s", diff)
+	}
+	want := []*fnv1.Result{{
+		Severity: fnv1.Severity_SEVERITY_NORMAL,
+		Message:  "other-xr-fghij can't find its subnet",
+		Reason:   ptr.To(reasonUnhealthy),
+	}, {
+		Severity: fnv1.Severity_SEVERITY_NORMAL,
+		Message:  "Reused a cached diagnosis of an identical failure",
+	}}
+	if diff := cmp.Diff(want, rsp.GetResults(), protocmp.Transform()); diff != "" {
+		t.Errorf("f.RunFunction(...): -want results, +got results:\n%s", diff)
+	}
+
+	// Cached diagnoses shouldn't be reused after their TTL.
+	f.cache.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if _, err := f.RunFunction(context.Background(), req("another-xr", "another-xr-klmno", "another-uid", "2025-06-06T12:00:00Z")); err != nil {
+		t.Fatalf("f.RunFunction(...): unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(2, len(c.calls)); diff != "" {
+		t.Errorf("f.RunFunction(...): expired diagnosis shouldn't be reused: -want calls, +got calls:\n%s", diff)
+	}
+}
+
+func TestIdentityReplacers(t *testing.T) {
+	xr := `{"metadata":{"name":"cool-xr","uid":"xr-uid"}}`
+	observed := observedResources{
+		"database": `{"metadata":{"name":"db","uid":"db-uid"}}`,
+	}
+	mask, unmask := identityReplacers(xr, observed)
+
+	cases := map[string]struct {
+		reason string
+		text   string
+		want   string
+	}{
+		"WholeTokens": {
+			reason: "Names and UIDs delimited by whitespace, quotes, or punctuation should be masked.",
+			text:   `db (db-uid) can't reach "cool-xr".`,
+			want:   `<resource:database:name> (<resource:database:uid>) can't reach "<composite:name>".`,
+		},
+		"WithinWords": {
+			reason: "Names within longer words shouldn't be masked.",
+			text:   "No feedback from dbs or cool-xr-cache.",
+			want:   "No feedback from dbs or cool-xr-cache.",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			masked := mask.Replace(tc.text)
+			if diff := cmp.Diff(tc.want, masked); diff != "" {
+				t.Errorf("%s\nmask.Replace(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.text, unmask.Replace(masked)); diff != "" {
+				t.Errorf("%s\nunmask.Replace(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestBoltCacheBackend(t *testing.T) {
+	b, err := NewBoltCacheBackend(filepath.Join(t.TempDir(), "diagnoses.db"), 2)
+	if err != nil {
+		t.Fatalf("NewBoltCacheBackend(...): unexpected error: %v", err)
+	}
+	defer b.Close() //nolint:errcheck // The test is over.
+
+	ctx := context.Background()
+	now := time.Now().Round(0)
+	for i, key := range []string{"soonest", "later", "latest"} {
+		cs := CachedStatus{
+			Version: "v1",
+			Expires: now.Add(time.Duration(i) * time.Minute),
+			Status:  CompositionStatus{ResourceStatuses: []composedResourceStatus{}, OverallStatus: overallStatusReady, Summary: key},
+		}
+		if err := b.Set(ctx, key, cs); err != nil {
+			t.Fatalf("b.Set(...): unexpected error: %v", err)
+		}
+	}
+
+	// The cache holds two statuses, so the one that expires soonest should
+	// have been evicted.
+	got, err := b.Get(ctx, "soonest")
+	if err != nil {
+		t.Fatalf("b.Get(...): unexpected error: %v", err)
+	}
+	if got != nil {
+		t.Errorf("b.Get(...): want status that expires soonest to be evicted, got %v", got)
+	}
+
+	got, err = b.Get(ctx, "latest")
+	if err != nil {
+		t.Fatalf("b.Get(...): unexpected error: %v", err)
+	}
+	want := &CachedStatus{
+		Version: "v1",
+		Expires: now.Add(2 * time.Minute),
+		Status:  CompositionStatus{ResourceStatuses: []composedResourceStatus{}, OverallStatus: overallStatusReady, Summary: "latest"},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.EquateApproxTime(time.Millisecond)); diff != "" {
+		t.Errorf("b.Get(...): -want, +got:\n%s", diff)
+	}
+}
+
+func TestConfigMapCacheBackend(t *testing.T) {
+	ctx := context.Background()
+	ref := types.NamespacedName{Namespace: "crossplane-system", Name: "diagnoses"}
+	c := fake.NewClientBuilder().Build()
+	b := NewConfigMapCacheBackend(c, c, ref, 2)
+
+	now := time.Now().Round(0)
+	b.now = func() time.Time { return now }
+
+	got, err := b.Get(ctx, "missing")
+	if err != nil {
+		t.Fatalf("b.Get(...): unexpected error: %v", err)
+	}
+	if got != nil {
+		t.Errorf("b.Get(...): want no status before the ConfigMap exists, got %v", got)
+	}
+
+	for i, key := range []string{"soonest", "later", "latest"} {
+		cs := CachedStatus{
+			Version: "v1",
+			Expires: now.Add(time.Duration(i) * time.Minute),
+			Status:  CompositionStatus{ResourceStatuses: []composedResourceStatus{}, OverallStatus: overallStatusReady, Summary: key},
+		}
+		if err := b.Set(ctx, key, cs); err != nil {
+			t.Fatalf("b.Set(...): unexpected error: %v", err)
+		}
+	}
+
+	// The cache holds two statuses, so the one that expires soonest should
+	// have been evicted from the ConfigMap.
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(ctx, ref, cm); err != nil {
+		t.Fatalf("c.Get(...): unexpected error: %v", err)
+	}
+	keys := []string{}
+	for k := range cm.Data {
+		keys = append(keys, k)
+	}
+	if diff := cmp.Diff([]string{"later", "latest"}, keys, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+		t.Errorf("b.Set(...): -want ConfigMap keys, +got ConfigMap keys:\n%s", diff)
+	}
+
+	got, err = b.Get(ctx, "latest")
+	if err != nil {
+		t.Fatalf("b.Get(...): unexpected error: %v", err)
+	}
+	want := &CachedStatus{
+		Version: "v1",
+		Expires: now.Add(2 * time.Minute),
+		Status:  CompositionStatus{ResourceStatuses: []composedResourceStatus{}, OverallStatus: overallStatusReady, Summary: "latest"},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.EquateApproxTime(time.Millisecond)); diff != "" {
+		t.Errorf("b.Get(...): -want, +got:\n%s", diff)
+	}
+
+	// Statuses cached by another replica should only be seen once the
+	// ConfigMap is read again.
+	cm.Data["other"] = cm.Data["latest"]
+	if err := c.Update(ctx, cm); err != nil {
+		t.Fatalf("c.Update(...): unexpected error: %v", err)
+	}
+	if got, _ := b.Get(ctx, "other"); got != nil {
+		t.Errorf("b.Get(...): want the ConfigMap's data to be reused within the refresh interval, got %v", got)
+	}
+	now = now.Add(configMapCacheRefresh)
+	if got, _ := b.Get(ctx, "other"); got == nil {
+		t.Errorf("b.Get(...): want the ConfigMap to be read again after the refresh interval, got nil")
+	}
+
+	// Statuses that have expired shouldn't be reused.
+	sc := newStatusCache(b, time.Hour, false)
+	sc.now = func() time.Time { return now }
+	sig := signature{key: "expiring", version: "v1"}
+	if err := sc.Add(ctx, sig, CompositionStatus{ResourceStatuses: []composedResourceStatus{}, OverallStatus: overallStatusReady, Summary: "expiring"}); err != nil {
+		t.Fatalf("sc.Add(...): unexpected error: %v", err)
+	}
+	if _, ok, _ := sc.Get(ctx, sig); !ok {
+		t.Errorf("sc.Get(...): want a status that hasn't expired to be reused")
+	}
+	now = now.Add(2 * time.Hour)
+	if _, ok, _ := sc.Get(ctx, sig); ok {
+		t.Errorf("sc.Get(...): want a status that has expired not to be reused")
+	}
+}
+
+func TestRunFunctionCoalesce(t *testing.T) {
+	req := &fnv1.RunFunctionRequest{
+		Input: resource.MustStructJSON(`{
+			"apiVersion": "function-claude-status-transformer.fn.crossplane.io/v1beta1",
+			"kind": "StatusTransformation",
+			"additionalContext": ""
+		}`),
+		Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(`{
+			"apiVersion": "example.org/v1",
+			"kind": "XR",
+			"metadata": {"name": "cool-xr", "uid": "cool-uid"}
+		}`)}},
+	}
+
+	valid := `{"resourceStatuses":[],"overallStatus":"Ready","summary":"No unhealthy resources found"}`
+	c := &blockingMessageClient{
+		response: toolUse("1", submitStatusToolName, valid),
+		started:  make(chan struct{}),
+		release:  make(chan struct{}),
+	}
+	f := NewFunction(logging.NewNopLogger())
+	f.newClient = func(_ context.Context, _ *v1beta1.StatusTransformation, _ *fnv1.RunFunctionRequest) (MessageClient, error) {
+		return c, nil
+	}
+
+	const callers = 5
+	rsps := make([]*fnv1.RunFunctionResponse, callers)
+	wg := sync.WaitGroup{}
+	run := func(i int) {
+		defer wg.Done()
+		rsp, err := f.RunFunction(context.Background(), req)
+		if err != nil {
+			t.Errorf("f.RunFunction(...): unexpected error: %v", err)
+		}
+		rsps[i] = rsp
+	}
+
+	// Start one analysis, wait for it to message Claude, then start the
+	// rest while it's in flight.
+	wg.Add(callers)
+	go run(0)
+	<-c.started
+	for i := 1; i < callers; i++ {
+		go run(i)
+	}
+
+	// Give the other calls time to join the in-flight analysis.
+	time.Sleep(100 * time.Millisecond)
+	close(c.release)
+	wg.Wait()
+
+	if diff := cmp.Diff(int32(1), c.calls.Load()); diff != "" {
+		t.Errorf("f.RunFunction(...): concurrent calls should share one analysis: -want calls, +got calls:\n%s", diff)
+	}
+	for i, rsp := range rsps {
+		if diff := cmp.Diff(2, len(rsp.GetConditions())); diff != "" {
+			t.Errorf("f.RunFunction(...): call %d should get the shared status: -want conditions, +got conditions:\n%s", i, diff)
+		}
+	}
+}
+
+func TestRunFunctionCoalesceDeadline(t *testing.T) {
+	req := &fnv1.RunFunctionRequest{
+		Input: resource.MustStructJSON(`{
+			"apiVersion": "function-claude-status-transformer.fn.crossplane.io/v1beta1",
+			"kind": "StatusTransformation",
+			"additionalContext": ""
+		}`),
+		Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(`{
+			"apiVersion": "example.org/v1",
+			"kind": "XR",
+			"metadata": {"name": "cool-xr", "uid": "cool-uid"}
+		}`)}},
+	}
+
+	valid := `{"resourceStatuses":[],"overallStatus":"Ready","summary":"No unhealthy resources found"}`
+	c := &blockingMessageClient{
+		response: toolUse("1", submitStatusToolName, valid),
+		started:  make(chan struct{}),
+		release:  make(chan struct{}),
+	}
+	f := NewFunction(logging.NewNopLogger())
+	f.newClient = func(_ context.Context, _ *v1beta1.StatusTransformation, _ *fnv1.RunFunctionRequest) (MessageClient, error) {
+		return c, nil
+	}
+
+	// Start an analysis, and wait for it to message Claude.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := f.RunFunction(context.Background(), req); err != nil {
+			t.Errorf("f.RunFunction(...): unexpected error: %v", err)
+		}
+	}()
+	<-c.started
+
+	// A call that joins the in-flight analysis should return when its
+	// own deadline passes, rather than when the analysis finishes.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	rsp, err := f.RunFunction(ctx, req)
+	if err != nil {
+		t.Fatalf("f.RunFunction(...): unexpected error: %v", err)
+	}
+
+	close(c.release)
+	<-done
+
+	warning := false
+	for _, r := range rsp.GetResults() {
+		if r.GetSeverity() == fnv1.Severity_SEVERITY_WARNING {
+			warning = true
+		}
+	}
+	if !warning {
+		t.Errorf("f.RunFunction(...): want a warning when the deadline passes while waiting for a concurrent analysis, got results %v", rsp.GetResults())
+	}
+	if diff := cmp.Diff(int32(1), c.calls.Load()); diff != "" {
+		t.Errorf("f.RunFunction(...): the waiting call shouldn't message Claude: -want calls, +got calls:\n%s", diff)
+	}
+}
+
+func TestRunFunctionDeferred(t *testing.T) {
+	req := &fnv1.RunFunctionRequest{
+		Input: resource.MustStructJSON(`{
+			"apiVersion": "function-claude-status-transformer.fn.crossplane.io/v1beta1",
+			"kind": "StatusTransformation",
+			"additionalContext": ""
+		}`),
+		Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(`{
+			"apiVersion": "example.org/v1",
+			"kind": "XR",
+			"metadata": {"name": "cool-xr"},
+			"status": {"conditions": [{
+				"type": "HealthyAccordingToClaude",
+				"status": "True",
+				"reason": "[]",
+				"message": "No unhealthy resources found",
+				"lastTransitionTime": "2025-06-04T16:00:00Z"
+			}]}
+		}`)}},
+	}
+
+	c := &fakeMessageClient{}
+	f := NewFunction(logging.NewNopLogger(), WithThrottle(Throttle{MaxConcurrentCalls: 1, QueueTimeout: 10 * time.Millisecond}))
+	f.newClient = func(_ context.Context, _ *v1beta1.StatusTransformation, _ *fnv1.RunFunctionRequest) (MessageClient, error) {
+		return c, nil
+	}
+
+	// Another analysis is messaging Claude, and won't finish in time.
+	release, err := f.throttle.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("f.throttle.Acquire(...): unexpected error: %v", err)
+	}
+	defer release()
+
+	rsp, err := f.RunFunction(context.Background(), req)
+	if err != nil {
+		t.Fatalf("f.RunFunction(...): unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(0, len(c.calls)); diff != "" {
+		t.Errorf("f.RunFunction(...): deferred analysis shouldn't message Claude: -want calls, +got calls:\n%s", diff)
+	}
+
+	wantConditions := []*fnv1.Condition{{
+		Type:    string(conditionTypeClaudeHealthy),
+		Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+		Reason:  "[]",
+		Message: ptr.To("No unhealthy resources found"),
+	}}
+	if diff := cmp.Diff(wantConditions, rsp.GetConditions(), protocmp.Transform()); diff != "" {
+		t.Errorf("f.RunFunction(...): deferred analysis should keep the last status: -want, +got:\n%s", diff)
+	}
+	if len(rsp.GetResults()) != 1 || rsp.GetResults()[0].GetSeverity() != fnv1.Severity_SEVERITY_NORMAL || !strings.HasPrefix(rsp.GetResults()[0].GetMessage(), "Deferred analysis") {
+		t.Errorf("f.RunFunction(...): want a single Normal result noting the analysis was deferred, got %v", rsp.GetResults())
+	}
+}
+
+func TestRunFunctionMinInterval(t *testing.T) {
+	input := resource.MustStructJSON(`{
+		"apiVersion": "function-claude-status-transformer.fn.crossplane.io/v1beta1",
+		"kind": "StatusTransformation",
+		"additionalContext": "",
+		"minInterval": "10m"
+	}`)
+
+	// req returns a request for an XR Claude last analyzed at the supplied
+	// time.
+	req := func(analyzedAt time.Time) *fnv1.RunFunctionRequest {
+		return &fnv1.RunFunctionRequest{
+			Input: input,
+			Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(fmt.Sprintf(`{
+				"apiVersion": "example.org/v1",
+				"kind": "XR",
+				"metadata": {"name": "cool-xr"},
+				"status": {"conditions": [{
+					"type": "HealthyAccordingToClaude",
+					"status": "True",
+					"reason": "[]",
+					"message": "No unhealthy resources found"
+				}, {
+					"type": "ClaudeLastAnalyzed",
+					"status": "True",
+					"reason": "Analyzed",
+					"message": %q
+				}]}
+			}`, analyzedAt.UTC().Format(time.RFC3339)))}},
+		}
+	}
+
+	valid := `{"resourceStatuses":[],"overallStatus":"Ready","summary":"No unhealthy resources found"}`
+
+	type want struct {
+		calls int
+		ttl   time.Duration
+	}
+
+	cases := map[string]struct {
+		reason     string
+		analyzedAt time.Time
+		want       want
+	}{
+		"WithinInterval": {
+			reason:     "We should keep the last status, and come back when the next analysis is due, if Claude analyzed the composition recently.",
+			analyzedAt: time.Now().Add(-1 * time.Minute),
+			want: want{
+				calls: 0,
+				ttl:   9 * time.Minute,
+			},
+		},
+		"IntervalPassed": {
+			reason:     "We should analyze the composition, and come back after the interval, if Claude last analyzed it long enough ago.",
+			analyzedAt: time.Now().Add(-11 * time.Minute),
+			want: want{
+				calls: 1,
+				ttl:   10 * time.Minute,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := &fakeMessageClient{responses: []string{toolUse("1", submitStatusToolName, valid)}}
+			f := NewFunction(logging.NewNopLogger())
+			f.newClient = func(_ context.Context, _ *v1beta1.StatusTransformation, _ *fnv1.RunFunctionRequest) (MessageClient, error) {
+				return c, nil
+			}
+
+			rsp, err := f.RunFunction(context.Background(), req(tc.analyzedAt))
+			if err != nil {
+				t.Fatalf("%s\nf.RunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.calls, len(c.calls)); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want calls, +got calls:\n%s", tc.reason, diff)
+			}
+			// Allow for the time the test takes to run.
+			if got := rsp.GetMeta().GetTtl().AsDuration(); got < tc.want.ttl-5*time.Second || got > tc.want.ttl+5*time.Second {
+				t.Errorf("%s\nf.RunFunction(...): want ttl of about %s, got %s", tc.reason, tc.want.ttl, got)
+			}
+			if (output{analyzedType: conditionTypeClaudeAnalyzed}).analyzedAt(rsp).IsZero() {
+				t.Errorf("%s\nf.RunFunction(...): want the %s condition to be set", tc.reason, conditionTypeClaudeAnalyzed)
+			}
+		})
+	}
+}
+
+func TestCheckBudget(t *testing.T) {
+	now := time.Date(2025, time.June, 30, 12, 0, 0, 0, time.UTC)
+
+	fc := func(b *v1alpha1.Budget, u *v1alpha1.Usage) *v1alpha1.FunctionConfig {
+		return &v1alpha1.FunctionConfig{
+			Spec:   v1alpha1.FunctionConfigSpec{Budget: b},
+			Status: v1alpha1.FunctionConfigStatus{Usage: u},
+		}
+	}
+
+	cases := map[string]struct {
+		reason     string
+		fc         *v1alpha1.FunctionConfig
+		unrecorded int64
+		wantErr    bool
+	}{
+		"NoFunctionConfig": {
+			reason: "We should allow any usage without a FunctionConfig.",
+		},
+		"NoBudget": {
+			reason: "We should allow any usage if the FunctionConfig has no budget.",
+			fc:     fc(nil, &v1alpha1.Usage{Day: "2025-06-30", DailyTokens: 1000000, Month: "2025-06", MonthlyTokens: 1000000}),
+		},
+		"WithinBudget": {
+			reason: "We should allow usage within the budget.",
+			fc:     fc(&v1alpha1.Budget{DailyTokens: ptr.To[int64](1000), MonthlyTokens: ptr.To[int64](10000)}, &v1alpha1.Usage{Day: "2025-06-30", DailyTokens: 500, Month: "2025-06", MonthlyTokens: 5000}),
+		},
+		"DailyBudgetUsed": {
+			reason:  "We should return an error if the daily budget is used.",
+			fc:      fc(&v1alpha1.Budget{DailyTokens: ptr.To[int64](1000)}, &v1alpha1.Usage{Day: "2025-06-30", DailyTokens: 1000, Month: "2025-06", MonthlyTokens: 1000}),
+			wantErr: true,
+		},
+		"DailyBudgetUsedYesterday": {
+			reason: "We should reset daily usage counted on an earlier day.",
+			fc:     fc(&v1alpha1.Budget{DailyTokens: ptr.To[int64](1000)}, &v1alpha1.Usage{Day: "2025-06-29", DailyTokens: 1000, Month: "2025-06", MonthlyTokens: 1000}),
+		},
+		"MonthlyBudgetUsed": {
+			reason:  "We should return an error if the monthly budget is used, even if the daily budget isn't.",
+			fc:      fc(&v1alpha1.Budget{DailyTokens: ptr.To[int64](1000), MonthlyTokens: ptr.To[int64](10000)}, &v1alpha1.Usage{Day: "2025-06-29", DailyTokens: 1000, Month: "2025-06", MonthlyTokens: 10000}),
+			wantErr: true,
+		},
+		"UnrecordedUsage": {
+			reason:     "We should count tokens used by the current analysis toward the budget.",
+			fc:         fc(&v1alpha1.Budget{DailyTokens: ptr.To[int64](1000)}, &v1alpha1.Usage{Day: "2025-06-30", DailyTokens: 500, Month: "2025-06", MonthlyTokens: 500}),
+			unrecorded: 500,
+			wantErr:    true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := checkBudget(tc.fc, now, tc.unrecorded)
+			if diff := cmp.Diff(tc.wantErr, err != nil); diff != "" {
+				t.Errorf("%s\ncheckBudget(...): -want error, +got error:\n%s\n%v", tc.reason, diff, err)
+			}
+		})
+	}
+}
+
+func TestRecordUsage(t *testing.T) {
+	last := &v1alpha1.Usage{Day: "2025-03-15", DailyTokens: 100, Month: "2025-03", MonthlyTokens: 1000}
+
+	cases := map[string]struct {
+		reason string
+		last   *v1alpha1.Usage
+		now    time.Time
+		want   *v1alpha1.Usage
+	}{
+		"FirstUsage": {
+			reason: "Usage should be counted from zero if none is recorded.",
+			now:    time.Date(2025, 3, 15, 12, 0, 0, 0, time.UTC),
+			want:   &v1alpha1.Usage{Day: "2025-03-15", DailyTokens: 100, Month: "2025-03", MonthlyTokens: 100},
+		},
+		"SameDay": {
+			reason: "Usage during the same day should accumulate.",
+			last:   last,
+			now:    time.Date(2025, 3, 15, 23, 59, 0, 0, time.UTC),
+			want:   &v1alpha1.Usage{Day: "2025-03-15", DailyTokens: 200, Month: "2025-03", MonthlyTokens: 1100},
+		},
+		"NextDay": {
+			reason: "Daily usage should reset at the start of a new day, but monthly usage should accumulate.",
+			last:   last,
+			now:    time.Date(2025, 3, 16, 0, 0, 0, 0, time.UTC),
+			want:   &v1alpha1.Usage{Day: "2025-03-16", DailyTokens: 100, Month: "2025-03", MonthlyTokens: 1100},
+		},
+		"NextMonth": {
+			reason: "Daily and monthly usage should reset at the start of a new month.",
+			last:   last,
+			now:    time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC),
+			want:   &v1alpha1.Usage{Day: "2025-04-01", DailyTokens: 100, Month: "2025-04", MonthlyTokens: 100},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			if err := v1alpha1.AddToScheme(s); err != nil {
+				t.Fatalf("v1alpha1.AddToScheme(...): unexpected error: %v", err)
+			}
+			fc := &v1alpha1.FunctionConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "cool-config"},
+				Status:     v1alpha1.FunctionConfigStatus{Usage: tc.last},
+			}
+			c := fake.NewClientBuilder().WithScheme(s).WithObjects(fc).WithStatusSubresource(fc).Build()
+
+			f := NewFunction(logging.NewNopLogger(), WithClient(c))
+			f.now = func() time.Time { return tc.now }
+
+			// Record usage twice, to show that it accumulates.
+			f.recordUsage(context.Background(), f.log, fc, 50)
+			f.recordUsage(context.Background(), f.log, fc, 50)
+
+			got := &v1alpha1.FunctionConfig{}
+			if err := c.Get(context.Background(), types.NamespacedName{Name: "cool-config"}, got); err != nil {
+				t.Fatalf("c.Get(...): unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got.Status.Usage); diff != "" {
+				t.Errorf("%s\nf.recordUsage(...): -want usage, +got usage:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestRunFunctionRetry(t *testing.T) {
+	req := &fnv1.RunFunctionRequest{
+		Input: resource.MustStructJSON(`{
+			"apiVersion": "function-claude-status-transformer.fn.crossplane.io/v1beta1",
+			"kind": "StatusTransformation",
+			"additionalContext": ""
+		}`),
+		Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(`{
+			"apiVersion": "example.org/v1",
+			"kind": "XR",
+			"metadata": {"name": "cool-xr", "uid": "cool-uid"}
+		}`)}},
+	}
+
+	valid := `{"resourceStatuses":[],"overallStatus":"Ready","summary":"No unhealthy resources found"}`
+
+	type want struct {
+		calls   int
+		warning bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		errs   []error
+		want   want
+	}{
+		"Overloaded": {
+			reason: "We should retry a message that fails because the API is overloaded.",
+			errs:   []error{apiError(529)},
+			want: want{
+				calls: 2,
+			},
+		},
+		"Throttled": {
+			reason: "We should retry a message that fails because it was throttled.",
+			errs:   []error{apiError(http.StatusTooManyRequests)},
+			want: want{
+				calls: 2,
+			},
+		},
+		"TooManyServerErrors": {
+			reason: "We should return a Warning if a message keeps failing after the maximum number of retries.",
+			errs:   []error{apiError(http.StatusInternalServerError), apiError(http.StatusBadGateway)},
+			want: want{
+				calls:   2,
+				warning: true,
+			},
+		},
+		"Unauthorized": {
+			reason: "We shouldn't retry a message that fails because the credentials are invalid.",
+			errs:   []error{apiError(http.StatusUnauthorized)},
+			want: want{
+				calls:   1,
+				warning: true,
+			},
+		},
+		"NetworkError": {
+			reason: "We should retry a message that fails because the provider can't be reached.",
+			errs:   []error{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}},
+			want: want{
+				calls: 2,
+			},
+		},
+		"ClientError": {
+			reason: "We shouldn't retry a message that fails before it reaches the provider.",
+			errs:   []error{errors.New("cannot load credentials")},
+			want: want{
+				calls:   1,
+				warning: true,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := &flakyMessageClient{errs: tc.errs, fakeMessageClient: fakeMessageClient{responses: []string{toolUse("1", submitStatusToolName, valid)}}}
+			f := NewFunction(logging.NewNopLogger(), WithRetry(Retry{MaxRetries: 1, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}))
+			f.newClient = func(_ context.Context, _ *v1beta1.StatusTransformation, _ *fnv1.RunFunctionRequest) (MessageClient, error) {
+				return c, nil
+			}
+
+			rsp, err := f.RunFunction(context.Background(), req)
+			if err != nil {
+				t.Fatalf("%s\nf.RunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.calls, c.failed+len(c.calls)); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want calls, +got calls:\n%s", tc.reason, diff)
+			}
+			warning := len(rsp.GetResults()) > 0 && rsp.GetResults()[0].GetSeverity() == fnv1.Severity_SEVERITY_WARNING
+			if diff := cmp.Diff(tc.want.warning, warning); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want warning, +got warning:\n%s\n%v", tc.reason, diff, rsp.GetResults())
+			}
+		})
+	}
+}
+
+func TestRunFunctionCircuitBreaker(t *testing.T) {
+	req := &fnv1.RunFunctionRequest{
+		Input: resource.MustStructJSON(`{
+			"apiVersion": "function-claude-status-transformer.fn.crossplane.io/v1beta1",
+			"kind": "StatusTransformation",
+			"additionalContext": ""
+		}`),
+		Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(`{
+			"apiVersion": "example.org/v1",
+			"kind": "XR",
+			"metadata": {"name": "cool-xr", "uid": "cool-uid"}
+		}`)}},
+	}
+
+	overloaded := func(n int) []error {
+		errs := make([]error, n)
+		for i := range errs {
+			errs[i] = apiError(529)
+		}
+		return errs
+	}
+
+	cases := map[string]struct {
+		reason string
+		retry  Retry
+		errs   []error
+		want   int
+	}{
+		"OpensAfterFailedAnalyses": {
+			reason: "The first two analyses fail, which opens the circuit breaker. The third shouldn't message Claude.",
+			retry:  Retry{BreakerThreshold: 2, BreakerCooldown: time.Hour},
+			errs:   overloaded(2),
+			want:   2,
+		},
+		"RetriesCountOnce": {
+			reason: "An analysis that fails after retrying should count once toward opening the circuit breaker.",
+			retry:  Retry{MaxRetries: 2, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond, BreakerThreshold: 2, BreakerCooldown: time.Hour},
+			errs:   overloaded(6),
+			want:   6,
+		},
+		"ClientErrorsDontCount": {
+			reason: "Errors returned before a message reaches the provider shouldn't open the circuit breaker.",
+			retry:  Retry{BreakerThreshold: 2, BreakerCooldown: time.Hour},
+			errs:   []error{errors.New("cannot load credentials"), errors.New("cannot load credentials"), errors.New("cannot load credentials")},
+			want:   3,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := &flakyMessageClient{errs: tc.errs}
+			f := NewFunction(logging.NewNopLogger(), WithRetry(tc.retry))
+			f.newClient = func(_ context.Context, _ *v1beta1.StatusTransformation, _ *fnv1.RunFunctionRequest) (MessageClient, error) {
+				return c, nil
+			}
+
+			for i := range 3 {
+				rsp, err := f.RunFunction(context.Background(), req)
+				if err != nil {
+					t.Fatalf("%s\nf.RunFunction(...): unexpected error: %v", tc.reason, err)
+				}
+				if len(rsp.GetResults()) != 1 || rsp.GetResults()[0].GetSeverity() != fnv1.Severity_SEVERITY_WARNING {
+					t.Errorf("%s\nf.RunFunction(...): call %d: want a single Warning result, got %v", tc.reason, i, rsp.GetResults())
+				}
+			}
+
+			if diff := cmp.Diff(tc.want, c.failed+len(c.calls)); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want calls, +got calls:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestRunFunctionRouting(t *testing.T) {
+	req := &fnv1.RunFunctionRequest{
+		Input: resource.MustStructJSON(`{
+			"apiVersion": "function-claude-status-transformer.fn.crossplane.io/v1beta1",
+			"kind": "StatusTransformation",
+			"additionalContext": "",
+			"routing": "Triage"
+		}`),
+		Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: resource.MustStructJSON(`{
+			"apiVersion": "example.org/v1",
+			"kind": "XR",
+			"metadata": {"name": "cool-xr", "uid": "cool-uid"}
+		}`)}},
+	}
+
+	valid := `{"resourceStatuses":[],"overallStatus":"Ready","summary":"No unhealthy resources found"}`
+
+	type want struct {
+		models []anthropic.Model
+		tier   string
+	}
+
+	cases := map[string]struct {
+		reason    string
+		responses []string
+		want      want
+	}{
+		"Triaged": {
+			reason:    "The triage model should diagnose a composition whose failure is simple.",
+			responses: []string{toolUse("1", triageToolName, `{"complexity":"Simple","status":`+valid+`}`)},
+			want: want{
+				models: []anthropic.Model{defaultAnthropicTriageModel},
+				tier:   tierTriage,
+			},
+		},
+		"Complex": {
+			reason: "The configured model should diagnose a composition the triage model finds complex.",
+			responses: []string{
+				toolUse("1", triageToolName, `{"complexity":"Complex"}`),
+				toolUse("2", submitStatusToolName, valid),
+			},
+			want: want{
+				models: []anthropic.Model{defaultAnthropicTriageModel, defaultAnthropicModel},
+				tier:   tierEscalated,
+			},
+		},
+		"InvalidTriageStatus": {
+			reason: "The configured model should diagnose a composition if the triage model's status is invalid.",
+			responses: []string{
+				toolUse("1", triageToolName, `{"complexity":"Simple","status":{"overallStatus":"Ready"}}`),
+				toolUse("2", submitStatusToolName, valid),
+			},
+			want: want{
+				models: []anthropic.Model{defaultAnthropicTriageModel, defaultAnthropicModel},
+				tier:   tierEscalated,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := &fakeMessageClient{responses: tc.responses}
+			f := NewFunction(logging.NewNopLogger())
+			f.newClient = func(_ context.Context, _ *v1beta1.StatusTransformation, _ *fnv1.RunFunctionRequest) (MessageClient, error) {
+				return c, nil
+			}
+
+			rsp, err := f.RunFunction(context.Background(), req)
+			if err != nil {
+				t.Fatalf("%s\nf.RunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+
+			models := make([]anthropic.Model, len(c.calls))
+			for i, call := range c.calls {
+				models[i] = call.Model
+			}
+			if diff := cmp.Diff(tc.want.models, models); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want models, +got models:\n%s", tc.reason, diff)
+			}
+
+			results := rsp.GetResults()
+			if len(results) != 2 || results[0].GetSeverity() != fnv1.Severity_SEVERITY_NORMAL {
+				t.Fatalf("%s\nf.RunFunction(...): want the status and tier results, got %v", tc.reason, results)
+			}
+			if diff := cmp.Diff(tc.want.tier, results[1].GetReason()); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want tier, +got tier:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestRunFunctionOutput(t *testing.T) {
+	input := func(output string) *structpb.Struct {
+		return resource.MustStructJSON(fmt.Sprintf(`{
+			"apiVersion": "function-claude-status-transformer.fn.crossplane.io/v1beta1",
+			"kind": "StatusTransformation",
+			"additionalContext": "",
+			"output": %s
+		}`, output))
+	}
+	db := &fnv1.Resource{Resource: resource.MustStructJSON(`{
+		"apiVersion": "rds.aws.upbound.io/v1beta1",
+		"kind": "RDSInstance",
+		"metadata": {"name": "cool-db"},
+		"status": {"conditions": [{
+			"type": "Ready",
+			"status": "False",
+			"reason": "ReconcileError",
+			"message": "Subnet not found"
+		}]}
+	}`)}
+
+	// observed returns the observed state of an XR with the supplied
+	// status, and an unhealthy composed resource.
+	observed := func(status string) *fnv1.State {
+		return &fnv1.State{
+			Composite: &fnv1.Resource{Resource: resource.MustStructJSON(fmt.Sprintf(`{
+				"apiVersion": "example.org/v1",
+				"kind": "XR",
+				"metadata": {"name": "cool-xr"},
+				"status": %s
+			}`, status))},
+			Resources: map[string]*fnv1.Resource{"db": db},
+		}
+	}
+
+	// analyzed returns the status of an XR Claude analyzed using the supplied
+	// input, with the supplied HealthyAccordingToClaude reason and extra
+	// fields. Its fingerprint is that of a request with the same input.
+	analyzed := func(in *structpb.Struct, reason, extra string) string {
+		fp, err := fingerprint(&fnv1.RunFunctionRequest{Input: in, Observed: observed(`{}`)})
+		if err != nil {
+			t.Fatalf("fingerprint(...): unexpected error: %v", err)
+		}
+		return fmt.Sprintf(`{%s"conditions": [{
+			"type": "HealthyAccordingToClaude",
+			"status": "False",
+			"reason": %q,
+			"message": "cool-db can't find its subnet"
+		}, {
+			"type": "ClaudeObservedFingerprint",
+			"status": "True",
+			"reason": "Analyzed",
+			"message": %q
+		}]}`, extra, reason, fp)
+	}
+
+	statuses := `[{"name":"cool-db","kind":"RDSInstance","apiVersion":"rds.aws.upbound.io/v1beta1","ready":false,"message":"Subnet not found"}]`
+	valid := `{"resourceStatuses":` + statuses + `,"overallStatus":"NotReady","summary":"cool-db can't find its subnet"}`
+	written := resource.MustStructJSON(`{"status": {"claude": ` + valid + `}}`)
+
+	type want struct {
+		calls   int
+		reason  string
+		desired *structpb.Struct
+		fatal   bool
+	}
+
+	cases := map[string]struct {
+		reason    string
+		req       *fnv1.RunFunctionRequest
+		clientErr error
+		responses []string
+		want      want
+	}{
+		"StatusMode": {
+			reason: "In Status mode the status should be written to the status path, and the condition should have a short reason.",
+			req: &fnv1.RunFunctionRequest{
+				Input:    input(`{"mode": "Status"}`),
+				Observed: observed(`{}`),
+			},
+			responses: []string{toolUse("1", submitStatusToolName, valid)},
+			want: want{
+				calls:   1,
+				reason:  reasonUnhealthy,
+				desired: written,
+			},
+		},
+		"StatusModeLegacyReason": {
+			reason: "In Status mode a status encoded in the condition reason by Reason mode should be migrated to the status path.",
+			req: &fnv1.RunFunctionRequest{
+				Input:    input(`{"mode": "Status"}`),
+				Observed: observed(analyzed(input(`{"mode": "Status"}`), statuses, "")),
+			},
+			want: want{
+				calls:   0,
+				reason:  reasonUnhealthy,
+				desired: written,
+			},
+		},
+		"ReasonModeWrittenStatus": {
+			reason: "In Reason mode a status written to the status path by Status mode should be migrated to the condition reason.",
+			req: &fnv1.RunFunctionRequest{
+				Input:    input(`{"mode": "Reason"}`),
+				Observed: observed(analyzed(input(`{"mode": "Reason"}`), reasonUnhealthy, `"claude": `+valid+`, `)),
+			},
+			want: want{
+				calls:  0,
+				reason: statuses,
+			},
+		},
+		"StatusModeClientError": {
+			reason: "In Status mode the last status should be kept at the status path if we can't get an LLM client.",
+			req: &fnv1.RunFunctionRequest{
+				Input:    input(`{"mode": "Status"}`),
+				Observed: observed(`{"claude": ` + valid + `}`),
+			},
+			clientErr: errors.New("boom"),
+			want: want{
+				calls:   0,
+				reason:  reasonUnhealthy,
+				desired: written,
+			},
+		},
+		"InvalidStatusPath": {
+			reason: "We should return a fatal result if the status path isn't within the composite resource's status.",
+			req: &fnv1.RunFunctionRequest{
+				Input:    input(`{"mode": "Status", "statusPath": "spec.claude"}`),
+				Observed: observed(`{}`),
+			},
+			want: want{
+				fatal: true,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := &fakeMessageClient{responses: tc.responses}
+			f := NewFunction(logging.NewNopLogger())
+			f.newClient = func(_ context.Context, _ *v1beta1.StatusTransformation, _ *fnv1.RunFunctionRequest) (MessageClient, error) {
+				if tc.clientErr != nil {
+					return nil, tc.clientErr
+				}
+				return c, nil
+			}
+
+			rsp, err := f.RunFunction(context.Background(), tc.req)
+			if err != nil {
+				t.Fatalf("%s\nf.RunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.calls, len(c.calls)); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want calls, +got calls:\n%s", tc.reason, diff)
+			}
+
+			reason := ""
+			for _, cond := range rsp.GetConditions() {
+				if cond.GetType() == string(conditionTypeClaudeHealthy) {
+					reason = cond.GetReason()
+				}
+			}
+			if diff := cmp.Diff(tc.want.reason, reason); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want condition reason, +got condition reason:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.desired, rsp.GetDesired().GetComposite().GetResource(), protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want desired composite resource, +got desired composite resource:\n%s", tc.reason, diff)
+			}
+
+			fatal := false
+			for _, r := range rsp.GetResults() {
+				if r.GetSeverity() == fnv1.Severity_SEVERITY_FATAL {
+					fatal = true
+				}
+			}
+			if diff := cmp.Diff(tc.want.fatal, fatal); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want fatal result, +got fatal result:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestRunFunctionOutputConditions(t *testing.T) {
+	input := func(output string) *structpb.Struct {
+		return resource.MustStructJSON(fmt.Sprintf(`{
+			"apiVersion": "function-claude-status-transformer.fn.crossplane.io/v1beta1",
+			"kind": "StatusTransformation",
+			"additionalContext": "",
+			"output": %s
+		}`, output))
+	}
+	db := &fnv1.Resource{Resource: resource.MustStructJSON(`{
+		"apiVersion": "rds.aws.upbound.io/v1beta1",
+		"kind": "RDSInstance",
+		"metadata": {"name": "cool-db"},
+		"status": {"conditions": [{
+			"type": "Ready",
+			"status": "False",
+			"reason": "ReconcileError",
+			"message": "Subnet not found"
+		}]}
+	}`)}
+
+	// observed returns the observed state of an XR with the supplied
+	// conditions, and an unhealthy composed resource.
+	observed := func(conditions string) *fnv1.State {
+		return &fnv1.State{
+			Composite: &fnv1.Resource{Resource: resource.MustStructJSON(fmt.Sprintf(`{
+				"apiVersion": "example.org/v1",
+				"kind": "XR",
+				"metadata": {"name": "cool-xr"},
+				"status": {"conditions": [%s]}
+			}`, conditions))},
+			Resources: map[string]*fnv1.Resource{"db": db},
+		}
+	}
+
+	custom := input(`{"conditionType": "DatabaseHealthy"}`)
+	fp, err := fingerprint(&fnv1.RunFunctionRequest{Input: custom, Observed: observed("")}, "DatabaseHealthy", "DatabaseHealthyObservedFingerprint", "DatabaseHealthyLastAnalyzed")
+	if err != nil {
+		t.Fatalf("fingerprint(...): unexpected error: %v", err)
+	}
+
+	// analyzed returns the conditions of an XR analyzed by the Function
+	// with the supplied condition types.
+	analyzed := func(healthy, fingerprint string) string {
+		return fmt.Sprintf(`{
+			"type": %q,
+			"status": "False",
+			"reason": "[]",
+			"message": "cool-db can't find its subnet"
+		}, {
+			"type": %q,
+			"status": "True",
+			"reason": "Analyzed",
+			"message": %q
+		}`, healthy, fingerprint, fp)
+	}
+
+	valid := `{"resourceStatuses":[{"name":"cool-db","kind":"RDSInstance","apiVersion":"rds.aws.upbound.io/v1beta1","ready":false,"message":"Subnet not found"}],"overallStatus":"NotReady","summary":"cool-db can't find its subnet"}`
+
+	type want struct {
+		calls int
+
+		// conditions maps the type of each condition to its target.
+		conditions map[string]fnv1.Target
+
+		// results are the targets of each non-fatal result.
+		results []fnv1.Target
+
+		fatal bool
+	}
+
+	cases := map[string]struct {
+		reason    string
+		req       *fnv1.RunFunctionRequest
+		responses []string
+		want      want
+	}{
+		"CustomConditionType": {
+			reason: "The status should be set as a condition of the configured type, and the analysis recorded by conditions named after it.",
+			req: &fnv1.RunFunctionRequest{
+				Input:    custom,
+				Observed: observed(""),
+			},
+			responses: []string{toolUse("1", submitStatusToolName, valid)},
+			want: want{
+				calls: 1,
+				conditions: map[string]fnv1.Target{
+					"DatabaseHealthy":                    fnv1.Target_TARGET_UNSPECIFIED,
+					"DatabaseHealthyObservedFingerprint": fnv1.Target_TARGET_UNSPECIFIED,
+				},
+				results: []fnv1.Target{fnv1.Target_TARGET_UNSPECIFIED},
+			},
+		},
+		"CustomConditionTypeUnchanged": {
+			reason: "The last status should be read from the conditions of the configured type.",
+			req: &fnv1.RunFunctionRequest{
+				Input:    custom,
+				Observed: observed(analyzed("DatabaseHealthy", "DatabaseHealthyObservedFingerprint")),
+			},
+			want: want{
+				calls: 0,
+				conditions: map[string]fnv1.Target{
+					"DatabaseHealthy":                    fnv1.Target_TARGET_UNSPECIFIED,
+					"DatabaseHealthyObservedFingerprint": fnv1.Target_TARGET_UNSPECIFIED,
+				},
+			},
+		},
+		"OtherConditionType": {
+			reason: "The conditions set by a pipeline step with another condition type should be ignored.",
+			req: &fnv1.RunFunctionRequest{
+				Input:    custom,
+				Observed: observed(analyzed(string(conditionTypeClaudeHealthy), string(conditionTypeClaudeFingerprint))),
+			},
+			responses: []string{toolUse("1", submitStatusToolName, valid)},
+			want: want{
+				calls: 1,
+				conditions: map[string]fnv1.Target{
+					"DatabaseHealthy":                    fnv1.Target_TARGET_UNSPECIFIED,
+					"DatabaseHealthyObservedFingerprint": fnv1.Target_TARGET_UNSPECIFIED,
+				},
+				results: []fnv1.Target{fnv1.Target_TARGET_UNSPECIFIED},
+			},
+		},
+		"CompositeAndClaim": {
+			reason: "The condition and results should target the claim too, but the conditions that record the analysis shouldn't.",
+			req: &fnv1.RunFunctionRequest{
+				Input:    input(`{"target": "CompositeAndClaim", "suggestionResults": true}`),
+				Observed: observed(""),
+			},
+			responses: []string{toolUse("1", submitStatusToolName, `{"resourceStatuses":[{"name":"cool-db","kind":"RDSInstance","apiVersion":"rds.aws.upbound.io/v1beta1","ready":false,"message":"Subnet not found","suggestedFix":"Create the subnet."}],"overallStatus":"NotReady","summary":"cool-db can't find its subnet"}`)},
+			want: want{
+				calls: 1,
+				conditions: map[string]fnv1.Target{
+					string(conditionTypeClaudeHealthy):     fnv1.Target_TARGET_COMPOSITE_AND_CLAIM,
+					string(conditionTypeClaudeFingerprint): fnv1.Target_TARGET_UNSPECIFIED,
+				},
+				results: []fnv1.Target{fnv1.Target_TARGET_COMPOSITE_AND_CLAIM, fnv1.Target_TARGET_COMPOSITE_AND_CLAIM},
+			},
+		},
+		"EmitConditions": {
+			reason: "Only conditions should be emitted when emit is Conditions.",
+			req: &fnv1.RunFunctionRequest{
+				Input:    input(`{"emit": "Conditions"}`),
+				Observed: observed(""),
+			},
+			responses: []string{toolUse("1", submitStatusToolName, valid)},
+			want: want{
+				calls: 1,
+				conditions: map[string]fnv1.Target{
+					string(conditionTypeClaudeHealthy):     fnv1.Target_TARGET_UNSPECIFIED,
+					string(conditionTypeClaudeFingerprint): fnv1.Target_TARGET_UNSPECIFIED,
+				},
+			},
+		},
+		"EmitResults": {
+			reason: "Only results and the conditions that record the analysis should be emitted when emit is Results.",
+			req: &fnv1.RunFunctionRequest{
+				Input:    input(`{"mode": "Status", "emit": "Results"}`),
+				Observed: observed(""),
+			},
+			responses: []string{toolUse("1", submitStatusToolName, valid)},
+			want: want{
+				calls: 1,
+				conditions: map[string]fnv1.Target{
+					string(conditionTypeClaudeFingerprint): fnv1.Target_TARGET_UNSPECIFIED,
+				},
+				results: []fnv1.Target{fnv1.Target_TARGET_UNSPECIFIED},
+			},
+		},
+		"EmitResultsReasonMode": {
+			reason: "We should return a fatal result if emit is Results in Reason mode, since the last status wouldn't be kept.",
+			req: &fnv1.RunFunctionRequest{
+				Input:    input(`{"emit": "Results"}`),
+				Observed: observed(""),
+			},
+			want: want{
+				conditions: map[string]fnv1.Target{},
+				fatal:      true,
+			},
+		},
+		"ReservedConditionType": {
+			reason: "We should return a fatal result if the condition type is one Crossplane sets.",
+			req: &fnv1.RunFunctionRequest{
+				Input:    input(`{"conditionType": "Ready"}`),
+				Observed: observed(""),
+			},
+			want: want{
+				conditions: map[string]fnv1.Target{},
+				fatal:      true,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := &fakeMessageClient{responses: tc.responses}
+			f := NewFunction(logging.NewNopLogger())
+			f.newClient = func(_ context.Context, _ *v1beta1.StatusTransformation, _ *fnv1.RunFunctionRequest) (MessageClient, error) {
+				return c, nil
+			}
+
+			rsp, err := f.RunFunction(context.Background(), tc.req)
+			if err != nil {
+				t.Fatalf("%s\nf.RunFunction(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.calls, len(c.calls)); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want calls, +got calls:\n%s", tc.reason, diff)
+			}
+
+			conditions := map[string]fnv1.Target{}
+			for _, cond := range rsp.GetConditions() {
+				conditions[cond.GetType()] = cond.GetTarget()
+			}
+			if diff := cmp.Diff(tc.want.conditions, conditions); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want condition targets, +got condition targets:\n%s", tc.reason, diff)
+			}
+
+			var results []fnv1.Target
+			fatal := false
+			for _, r := range rsp.GetResults() {
+				if r.GetSeverity() == fnv1.Severity_SEVERITY_FATAL {
+					fatal = true
+					continue
+				}
+				results = append(results, r.GetTarget())
+			}
+			if diff := cmp.Diff(tc.want.results, results); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want result targets, +got result targets:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.fatal, fatal); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want fatal result, +got fatal result:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestCompositionStatusCategory(t *testing.T) {
+	type want struct {
+		reason   string
+		severity fnv1.Severity
+	}
+
+	cases := map[string]struct {
+		reason string
+		status CompositionStatus
+		want   want
+	}{
+		"Ready": {
+			reason: "A ready composition should have a Healthy reason and a Normal result.",
+			status: CompositionStatus{OverallStatus: overallStatusReady},
+			want: want{
+				reason:   reasonHealthy,
+				severity: fnv1.Severity_SEVERITY_NORMAL,
+			},
+		},
+		"Authentication": {
+			reason: "An authentication failure should need someone to act.",
+			status: CompositionStatus{OverallStatus: overallStatusNotReady, Category: categoryAuthentication},
+			want: want{
+				reason:   "AuthenticationFailed",
+				severity: fnv1.Severity_SEVERITY_WARNING,
+			},
+		},
+		"Transient": {
+			reason: "A transient failure may resolve itself.",
+			status: CompositionStatus{OverallStatus: overallStatusNotReady, Category: categoryTransient},
+			want: want{
+				reason:   "TransientFailure",
+				severity: fnv1.Severity_SEVERITY_NORMAL,
+			},
+		},
+		"ResourceCategory": {
+			reason: "A composition without a category should have the category of its first categorized resource.",
+			status: CompositionStatus{
+				OverallStatus:    overallStatusNotReady,
+				ResourceStatuses: []composedResourceStatus{{Name: "a"}, {Name: "b", Category: categoryQuota}},
+			},
+			want: want{
+				reason:   "QuotaExceeded",
+				severity: fnv1.Severity_SEVERITY_WARNING,
+			},
+		},
+		"Uncategorized": {
+			reason: "A composition without any category should be Unhealthy.",
+			status: CompositionStatus{OverallStatus: overallStatusNotReady},
+			want: want{
+				reason:   reasonUnhealthy,
+				severity: fnv1.Severity_SEVERITY_NORMAL,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want.reason, tc.status.reason()); diff != "" {
+				t.Errorf("%s\ns.reason(): -want, +got:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.severity, tc.status.severity()); diff != "" {
+				t.Errorf("%s\ns.severity(): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestSuggestionResults(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		status CompositionStatus
+		want   []*fnv1.Result
+	}{
+		"NoSuggestions": {
+			reason: "We shouldn't return results for resources without a suggested fix.",
+			status: CompositionStatus{
+				OverallStatus:    overallStatusNotReady,
+				ResourceStatuses: []composedResourceStatus{{Name: "cool-db", Kind: "RDSInstance", Message: "Subnet not found"}},
+			},
+			want: []*fnv1.Result{},
+		},
+		"Suggestions": {
+			reason: "We should return a Normal result for each suggested fix, including its docs URL.",
+			status: CompositionStatus{
+				OverallStatus: overallStatusNotReady,
+				ResourceStatuses: []composedResourceStatus{
+					{
+						Name:         "cool-db",
+						Kind:         "RDSInstance",
+						Message:      "Subnet group not found",
+						SuggestedFix: "Label the SubnetGroup to match spec.forProvider.dbSubnetGroupNameSelector.",
+						DocsURL:      "https://docs.crossplane.io/latest/concepts/managed-resources/#matching-by-selector",
+					},
+					{
+						Name:         "cool-role",
+						Kind:         "Role",
+						Message:      "Access denied",
+						SuggestedFix: "Grant the ProviderConfig's role iam:CreateRole.",
+					},
+				},
+			},
+			want: []*fnv1.Result{
+				{
+					Severity: fnv1.Severity_SEVERITY_NORMAL,
+					Message:  "To fix RDSInstance cool-db: Label the SubnetGroup to match spec.forProvider.dbSubnetGroupNameSelector. (see https://docs.crossplane.io/latest/concepts/managed-resources/#matching-by-selector)",
+					Reason:   ptr.To(reasonSuggestedFix),
+				},
+				{
+					Severity: fnv1.Severity_SEVERITY_NORMAL,
+					Message:  "To fix Role cool-role: Grant the ProviderConfig's role iam:CreateRole.",
+					Reason:   ptr.To(reasonSuggestedFix),
+				},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := output{}.suggestionResults(tc.status)
+			if diff := cmp.Diff(tc.want, got, protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nsuggestionResults(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	cases := map[string]struct {
+		reason   string
+		observed observedResources
+		want     []dependency
+	}{
+		"NoDependencies": {
+			reason: "Resources that only refer to ProviderConfigs and connection secrets have no dependencies.",
+			observed: observedResources{
+				"vpc": `{"kind":"VPC","metadata":{"name":"main-vpc"},"spec":{"providerConfigRef":{"name":"default"},"writeConnectionSecretToRef":{"name":"vpc-details"}}}`,
+			},
+			want: []dependency{},
+		},
+		"ReferencesSelectorsAndOwners": {
+			reason: "We should follow references, selectors, and owner references, noting those that resolve to nothing composed.",
+			observed: observedResources{
+				"db": `{
+					"kind": "RDSInstance",
+					"metadata": {
+						"name": "example-db-widget",
+						"ownerReferences": [{"kind": "Database", "name": "example-db", "uid": "xr-uid"}]
+					},
+					"spec": {
+						"forProvider": {
+							"dbSubnetGroupNameSelector": {"matchLabels": {"example-db": "subnet-group"}},
+							"vpcSecurityGroupIdSelector": {"matchLabels": {"example-db": "security-group"}}
+						},
+						"providerConfigRef": {"name": "default"}
+					}
+				}`,
+				"subnet": `{
+					"kind": "Subnet",
+					"metadata": {
+						"name": "example-subnet",
+						"uid": "subnet-uid",
+						"labels": {"crossplane.io/composite": "example-db", "example-db": "subnet-group"}
+					},
+					"spec": {"forProvider": {"vpcIdRef": {"name": "main-vpc"}}}
+				}`,
+				"route": `{
+					"kind": "RouteTableAssociation",
+					"metadata": {
+						"name": "example-route",
+						"ownerReferences": [{"kind": "Subnet", "name": "example-subnet", "uid": "subnet-uid"}]
+					},
+					"spec": {"forProvider": {"subnetIdRefs": [{"name": "example-subnet"}], "matchControllerSelector": {"matchControllerRef": true}}}
+				}`,
+			},
+			want: []dependency{
+				{resource: "db", dependsOn: "subnet", via: "spec.forProvider.dbSubnetGroupNameSelector"},
+				{resource: "db", dependsOn: "nothing matches example-db=security-group", via: "spec.forProvider.vpcSecurityGroupIdSelector"},
+				{resource: "route", dependsOn: "subnet", via: "metadata.ownerReferences[0]"},
+				{resource: "route", dependsOn: "subnet", via: "spec.forProvider.subnetIdRefs"},
+				{resource: "subnet", dependsOn: "main-vpc (not composed)", via: "spec.forProvider.vpcIdRef"},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := tc.observed.Dependencies()
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(dependency{})); diff != "" {
+				t.Errorf("%s\nDependencies(): -want, +got:\n%s", tc.reason, diff)
+			}
 		})
 	}
 }
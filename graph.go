@@ -0,0 +1,222 @@
+/*
+Copyright 2025 The Upbound Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// ignoredSpecFields are fields of a composed resource's spec that refer to
+// resources that aren't composed, like ProviderConfigs and connection secrets.
+var ignoredSpecFields = map[string]bool{
+	"providerConfigRef":           true,
+	"writeConnectionSecretToRef":  true,
+	"writeConnectionSecretsToRef": true,
+	"publishConnectionDetailsTo":  true,
+	"compositionRef":              true,
+	"compositionRevisionRef":      true,
+	"compositionSelector":         true,
+	"compositionRevisionSelector": true,
+	"environmentConfigRefs":       true,
+	"resourceRefs":                true,
+	"claimRef":                    true,
+}
+
+// A dependency of a composed resource on another resource, found by following
+// a reference, a selector, or an owner reference.
+type dependency struct {
+	// resource is the composition resource name of the dependent resource.
+	resource string
+
+	// dependsOn describes the resource depended on. It's the composition
+	// resource name of a composed resource, or describes a resource that
+	// isn't composed.
+	dependsOn string
+
+	// via is the path of the field that refers to the resource depended on.
+	via string
+}
+
+// Dependencies returns the dependencies of the composed resources, sorted by
+// dependent resource.
+func (r observedResources) Dependencies() []dependency {
+	found := map[dependency]bool{}
+	for _, name := range r.names() {
+		j := r[name]
+		gjson.Get(j, "spec").ForEach(func(k, v gjson.Result) bool {
+			if !ignoredSpecFields[k.String()] {
+				r.follow(found, name, "spec."+k.String(), k.String(), v)
+			}
+			return true
+		})
+		for i, ref := range gjson.Get(j, "metadata.ownerReferences").Array() {
+			r.owner(found, name, fmt.Sprintf("metadata.ownerReferences[%d]", i), ref)
+		}
+	}
+
+	deps := make([]dependency, 0, len(found))
+	for d := range found {
+		deps = append(deps, d)
+	}
+	sort.Slice(deps, func(i, j int) bool {
+		if deps[i].resource != deps[j].resource {
+			return deps[i].resource < deps[j].resource
+		}
+		if deps[i].via != deps[j].via {
+			return deps[i].via < deps[j].via
+		}
+		return deps[i].dependsOn < deps[j].dependsOn
+	})
+	return deps
+}
+
+// DependencySummary returns a compact table of the dependencies of the
+// composed resources.
+func (r observedResources) DependencySummary() string {
+	deps := r.Dependencies()
+	if len(deps) == 0 {
+		return "No dependencies between composed resources were found."
+	}
+
+	b := &strings.Builder{}
+	b.WriteString("| resource | depends on | via |\n")
+	b.WriteString("|---|---|---|\n")
+	for _, d := range deps {
+		fmt.Fprintf(b, "| %s | %s | %s |\n", d.resource, d.dependsOn, d.via)
+	}
+	return b.String()
+}
+
+// follow records the dependencies expressed by the supplied field of the
+// named composed resource. Fields named *Ref and *Refs reference resources by
+// name, and fields named *Selector select them by label.
+func (r observedResources) follow(found map[dependency]bool, name, path, key string, v gjson.Result) {
+	switch {
+	case strings.HasSuffix(key, "Ref") && v.IsObject():
+		r.reference(found, name, path, v)
+	case strings.HasSuffix(key, "Refs") && v.IsArray():
+		for _, ref := range v.Array() {
+			r.reference(found, name, path, ref)
+		}
+	case strings.HasSuffix(key, "Selector") && v.IsObject():
+		r.selector(found, name, path, v.Get("matchLabels"))
+	case v.IsObject():
+		v.ForEach(func(k, e gjson.Result) bool {
+			r.follow(found, name, path+"."+k.String(), k.String(), e)
+			return true
+		})
+	case v.IsArray():
+		for i, e := range v.Array() {
+			r.follow(found, name, fmt.Sprintf("%s[%d]", path, i), "", e)
+		}
+	}
+}
+
+// reference records the dependency of the named composed resource on the
+// resource the supplied reference names.
+func (r observedResources) reference(found map[dependency]bool, name, path string, ref gjson.Result) {
+	target := ref.Get("name").String()
+	if target == "" {
+		return
+	}
+	matched := false
+	for _, other := range r.names() {
+		if other != name && gjson.Get(r[other], "metadata.name").String() == target {
+			found[dependency{resource: name, dependsOn: other, via: path}] = true
+			matched = true
+		}
+	}
+	if !matched {
+		found[dependency{resource: name, dependsOn: fmt.Sprintf("%s (not composed)", target), via: path}] = true
+	}
+}
+
+// selector records the dependency of the named composed resource on the
+// resources the supplied labels select. A selector without labels, e.g. one
+// that only matches the controller reference, is ignored.
+func (r observedResources) selector(found map[dependency]bool, name, path string, matchLabels gjson.Result) {
+	labels := map[string]string{}
+	matchLabels.ForEach(func(k, v gjson.Result) bool {
+		labels[k.String()] = v.String()
+		return true
+	})
+	if len(labels) == 0 {
+		return
+	}
+
+	matched := false
+	for _, other := range r.names() {
+		if other == name {
+			continue
+		}
+		if matchesLabels(gjson.Get(r[other], "metadata.labels"), labels) {
+			found[dependency{resource: name, dependsOn: other, via: path}] = true
+			matched = true
+		}
+	}
+	if !matched {
+		found[dependency{resource: name, dependsOn: fmt.Sprintf("nothing matches %s", labelString(labels)), via: path}] = true
+	}
+}
+
+// owner records the dependency of the named composed resource on the composed
+// resource the supplied owner reference refers to. Owners that aren't
+// composed, like the composite resource, are ignored.
+func (r observedResources) owner(found map[dependency]bool, name, path string, ref gjson.Result) {
+	uid := ref.Get("uid").String()
+	for _, other := range r.names() {
+		if other == name {
+			continue
+		}
+		j := r[other]
+		switch {
+		case uid != "" && gjson.Get(j, "metadata.uid").String() == uid:
+		case gjson.Get(j, "kind").String() == ref.Get("kind").String() && gjson.Get(j, "metadata.name").String() == ref.Get("name").String():
+		default:
+			continue
+		}
+		found[dependency{resource: name, dependsOn: other, via: path}] = true
+	}
+}
+
+// matchesLabels returns true if the supplied JSON labels contain every one of
+// the supplied labels.
+func matchesLabels(have gjson.Result, want map[string]string) bool {
+	// Label keys often contain dots, so they can't be used as paths.
+	matched := 0
+	have.ForEach(func(k, v gjson.Result) bool {
+		if w, ok := want[k.String()]; ok && w == v.String() {
+			matched++
+		}
+		return true
+	})
+	return matched == len(want)
+}
+
+// labelString returns the supplied labels as sorted key=value pairs.
+func labelString(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
@@ -31,17 +31,97 @@ import (
 // FunctionConfig configures the function for interacting with AWS.
 // +kubebuilder:resource:scope=Cluster,categories={crossplane,function,aws}
 // +kubebuilder:storageversion
+// +kubebuilder:subresource:status
 type FunctionConfig struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec FunctionConfigSpec `json:"spec"`
+	Spec   FunctionConfigSpec   `json:"spec"`
+	Status FunctionConfigStatus `json:"status,omitempty"`
 }
 
 // FunctionConfigSpec provides CSP specific configurations for the Function.
 type FunctionConfigSpec struct {
 	// ForAWS is the AWS specific FunctionConfig specification.
 	ForAWS *AWSFunctionConfig `json:"forAWS,omitempty"`
+
+	// Prompt customizes the prompt sent to Claude by every Function input
+	// that references this FunctionConfig.
+	// +optional
+	Prompt *Prompt `json:"prompt,omitempty"`
+
+	// Budget caps the tokens Claude may use for every Function input that
+	// references this FunctionConfig.
+	// +optional
+	Budget *Budget `json:"budget,omitempty"`
+}
+
+// A Budget caps the tokens Claude may use. Input and output tokens, including
+// cached input tokens, count toward the budget. Days and months start at
+// midnight UTC.
+type Budget struct {
+	// DailyTokens is the maximum number of tokens Claude may use per day.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	DailyTokens *int64 `json:"dailyTokens,omitempty"`
+
+	// MonthlyTokens is the maximum number of tokens Claude may use per
+	// calendar month.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	MonthlyTokens *int64 `json:"monthlyTokens,omitempty"`
+}
+
+// FunctionConfigStatus is the observed state of a FunctionConfig.
+type FunctionConfigStatus struct {
+	// Usage is the number of tokens Claude has used for Function inputs
+	// that reference this FunctionConfig.
+	// +optional
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// Usage is the number of tokens Claude used during a day and a month.
+type Usage struct {
+	// Day the daily usage was counted, e.g. 2025-06-30.
+	Day string `json:"day"`
+
+	// DailyTokens is the number of tokens used during the day.
+	DailyTokens int64 `json:"dailyTokens"`
+
+	// Month the monthly usage was counted, e.g. 2025-06.
+	Month string `json:"month"`
+
+	// MonthlyTokens is the number of tokens used during the month.
+	MonthlyTokens int64 `json:"monthlyTokens"`
+}
+
+// Prompt customizes the prompt sent to Claude.
+type Prompt struct {
+	// System replaces or extends the system prompt, which tells Claude who
+	// it is, e.g. which teams own which kinds of resource.
+	// +optional
+	System *PromptTemplate `json:"system,omitempty"`
+
+	// Instructions replaces or extends the instructions that tell Claude
+	// how to analyze the composition.
+	// +optional
+	Instructions *PromptTemplate `json:"instructions,omitempty"`
+}
+
+// A PromptTemplate is a Go text/template. It's rendered with the composite
+// resource (.Composite), a summary of the composed resources (.Composed), their
+// dependencies (.Dependencies), the last status (.LastStatus), and the
+// additional context (.Input).
+type PromptTemplate struct {
+	// Mode determines whether the template is appended to or replaces the
+	// default prompt.
+	// +optional
+	// +kubebuilder:validation:Enum=Append;Replace
+	// +kubebuilder:default=Append
+	Mode string `json:"mode,omitempty"`
+
+	// Template is the Go text/template to render.
+	Template string `json:"template"`
 }
 
 // AWSFunctionConfig provides an subset of configurations that we currently
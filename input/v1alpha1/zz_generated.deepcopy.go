@@ -115,6 +115,31 @@ func (in *AssumeRoleWithWebIdentityOptions) DeepCopy() *AssumeRoleWithWebIdentit
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Budget) DeepCopyInto(out *Budget) {
+	*out = *in
+	if in.DailyTokens != nil {
+		in, out := &in.DailyTokens, &out.DailyTokens
+		*out = new(int64)
+		**out = **in
+	}
+	if in.MonthlyTokens != nil {
+		in, out := &in.MonthlyTokens, &out.MonthlyTokens
+		*out = new(int64)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Budget.
+func (in *Budget) DeepCopy() *Budget {
+	if in == nil {
+		return nil
+	}
+	out := new(Budget)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DynamicURLConfig) DeepCopyInto(out *DynamicURLConfig) {
 	*out = *in
@@ -187,6 +212,7 @@ func (in *FunctionConfig) DeepCopyInto(out *FunctionConfig) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FunctionConfig.
@@ -247,6 +273,16 @@ func (in *FunctionConfigSpec) DeepCopyInto(out *FunctionConfigSpec) {
 		*out = new(AWSFunctionConfig)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Prompt != nil {
+		in, out := &in.Prompt, &out.Prompt
+		*out = new(Prompt)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Budget != nil {
+		in, out := &in.Budget, &out.Budget
+		*out = new(Budget)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FunctionConfigSpec.
@@ -259,6 +295,26 @@ func (in *FunctionConfigSpec) DeepCopy() *FunctionConfigSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FunctionConfigStatus) DeepCopyInto(out *FunctionConfigStatus) {
+	*out = *in
+	if in.Usage != nil {
+		in, out := &in.Usage, &out.Usage
+		*out = new(Usage)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FunctionConfigStatus.
+func (in *FunctionConfigStatus) DeepCopy() *FunctionConfigStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(FunctionConfigStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FunctionCredentials) DeepCopyInto(out *FunctionCredentials) {
 	*out = *in
@@ -285,6 +341,46 @@ func (in *FunctionCredentials) DeepCopy() *FunctionCredentials {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Prompt) DeepCopyInto(out *Prompt) {
+	*out = *in
+	if in.System != nil {
+		in, out := &in.System, &out.System
+		*out = new(PromptTemplate)
+		**out = **in
+	}
+	if in.Instructions != nil {
+		in, out := &in.Instructions, &out.Instructions
+		*out = new(PromptTemplate)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Prompt.
+func (in *Prompt) DeepCopy() *Prompt {
+	if in == nil {
+		return nil
+	}
+	out := new(Prompt)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PromptTemplate) DeepCopyInto(out *PromptTemplate) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PromptTemplate.
+func (in *PromptTemplate) DeepCopy() *PromptTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(PromptTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Tag) DeepCopyInto(out *Tag) {
 	*out = *in
@@ -355,6 +451,21 @@ func (in *Upbound) DeepCopy() *Upbound {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Usage) DeepCopyInto(out *Usage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Usage.
+func (in *Usage) DeepCopy() *Usage {
+	if in == nil {
+		return nil
+	}
+	out := new(Usage)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WebIdentityTokenConfig) DeepCopyInto(out *WebIdentityTokenConfig) {
 	*out = *in
@@ -22,6 +22,7 @@ package v1beta1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // StatusTransformation can be used to provide input to this Function.
@@ -40,6 +41,227 @@ type StatusTransformation struct {
 	// +optional
 	// +kubebuilder:validation:Optional
 	AWS *AWS `json:"aws"`
+
+	// Anthropic configures the model and generation parameters used when
+	// talking to Anthropic's API directly. Ignored when AWS is configured.
+	// +optional
+	Anthropic *Anthropic `json:"anthropic,omitempty"`
+
+	// Mode determines how Claude is messaged. In Interactive mode the
+	// Function waits for Claude to analyze the composition. In Batch mode the
+	// Function submits the analysis using the Message Batches API and returns
+	// the previous status. A later call collects the result. Batch mode costs
+	// less, but requires Anthropic's API and can take up to 24 hours.
+	// +optional
+	// +kubebuilder:validation:Enum=Interactive;Batch
+	// +kubebuilder:default=Interactive
+	Mode Mode `json:"mode,omitempty"`
+
+	// Routing determines which models analyze the composition. In Direct
+	// mode the configured model analyzes it. In Triage mode a small triage
+	// model first classifies the composition, and diagnoses it if it's
+	// healthy or its failure is simple. The configured model only analyzes
+	// compositions the triage model escalates. Ignored in Batch mode.
+	// +optional
+	// +kubebuilder:validation:Enum=Direct;Triage
+	// +kubebuilder:default=Direct
+	Routing Routing `json:"routing,omitempty"`
+
+	// AnalyzeHealthy asks Claude to analyze the composition even when the
+	// composite resource and every composed resource are Ready and Synced.
+	// By default the Function reports such compositions as healthy without
+	// messaging Claude.
+	// +optional
+	AnalyzeHealthy bool `json:"analyzeHealthy,omitempty"`
+
+	// Thinking lets Claude reason before it submits a status, which can help
+	// it diagnose cascading failures across several resources.
+	// +optional
+	Thinking *Thinking `json:"thinking,omitempty"`
+
+	// Prompt customizes the system prompt and instructions sent to Claude.
+	// Fields set here take precedence over those of the FunctionConfig
+	// referenced by FunctionConfigReference.
+	// +optional
+	Prompt *Prompt `json:"prompt,omitempty"`
+
+	// Examples are example diagnoses that steer the tone and granularity of
+	// the statuses Claude produces.
+	// +optional
+	Examples *Examples `json:"examples,omitempty"`
+
+	// FunctionConfigReference references a FunctionConfig that provides
+	// shared configuration, such as a customized prompt. Requires the
+	// Function to be started with --enable-function-configs.
+	// +optional
+	FunctionConfigReference *Reference `json:"functionConfigRef,omitempty"`
+
+	// Limits bound how much work Claude may do to analyze the composition.
+	// Limits that aren't set default to the values the Function was started
+	// with.
+	// +optional
+	Limits *Limits `json:"limits,omitempty"`
+
+	// MinInterval is the minimum amount of time between analyses of the
+	// composition. Until it has passed the Function keeps the last status,
+	// even if the composition has changed.
+	// +optional
+	MinInterval *metav1.Duration `json:"minInterval,omitempty"`
+
+	// Output configures how the Function reports the status of the
+	// composition.
+	// +optional
+	Output *Output `json:"output,omitempty"`
+}
+
+// A Mode determines how Claude is messaged.
+type Mode string
+
+// Modes.
+const (
+	// ModeInteractive waits for Claude to analyze the composition.
+	ModeInteractive Mode = "Interactive"
+
+	// ModeBatch submits the analysis using the Message Batches API.
+	ModeBatch Mode = "Batch"
+)
+
+// Routing determines which models analyze the composition.
+type Routing string
+
+// Routing modes.
+const (
+	// RoutingDirect analyzes the composition using the configured model.
+	RoutingDirect Routing = "Direct"
+
+	// RoutingTriage triages the composition using a small model, and only
+	// escalates non-trivial failures to the configured model.
+	RoutingTriage Routing = "Triage"
+)
+
+// Output configures how the Function reports the status of the composition.
+type Output struct {
+	// Mode determines where the status of each composed resource is
+	// written. In Reason mode it's encoded as JSON in the reason of the
+	// HealthyAccordingToClaude condition. In Status mode the status of the
+	// composition is written to StatusPath of the composite resource, and
+	// the condition's reason is a short CamelCase code. The composite
+	// resource's schema must allow the status to be written.
+	// +optional
+	// +kubebuilder:validation:Enum=Reason;Status
+	// +kubebuilder:default=Reason
+	Mode OutputMode `json:"mode,omitempty"`
+
+	// StatusPath is the field path of the composite resource the status of
+	// the composition is written to in Status mode. It must be within the
+	// composite resource's status.
+	// +optional
+	// +kubebuilder:default="status.claude"
+	StatusPath string `json:"statusPath,omitempty"`
+
+	// SuggestionResults emits each fix Claude suggests as a separate Normal
+	// result, in addition to including it in the status of its composed
+	// resource.
+	// +optional
+	SuggestionResults bool `json:"suggestionResults,omitempty"`
+
+	// ConditionType is the type of the condition that represents the
+	// status of the composition. Set it to distinguish pipeline steps that
+	// use the Function. The conditions that record the analysis are named
+	// after it too, e.g. <ConditionType>LastAnalyzed, unless it's the
+	// default.
+	// +optional
+	// +kubebuilder:validation:Pattern=`^[A-Z][A-Za-z0-9]*$`
+	// +kubebuilder:default=HealthyAccordingToClaude
+	ConditionType string `json:"conditionType,omitempty"`
+
+	// Target determines whether the condition and results are set on the
+	// composite resource only, or on its claim too.
+	// +optional
+	// +kubebuilder:validation:Enum=Composite;CompositeAndClaim
+	// +kubebuilder:default=Composite
+	Target OutputTarget `json:"target,omitempty"`
+
+	// Emit determines whether the status of the composition is reported
+	// as a condition, as results, or both. Results requires Status mode,
+	// since results aren't persisted and the last status must be.
+	// +optional
+	// +kubebuilder:validation:Enum=ConditionsAndResults;Conditions;Results
+	// +kubebuilder:default=ConditionsAndResults
+	Emit OutputEmit `json:"emit,omitempty"`
+}
+
+// An OutputMode determines where the status of each composed resource is
+// written.
+type OutputMode string
+
+// Output modes.
+const (
+	// OutputModeReason encodes the status of each composed resource in the
+	// reason of the HealthyAccordingToClaude condition.
+	OutputModeReason OutputMode = "Reason"
+
+	// OutputModeStatus writes the status of the composition to a field of
+	// the composite resource's status.
+	OutputModeStatus OutputMode = "Status"
+)
+
+// An OutputTarget determines which resources the condition and results are
+// set on.
+type OutputTarget string
+
+// Output targets.
+const (
+	// OutputTargetComposite sets the condition and results on the
+	// composite resource only.
+	OutputTargetComposite OutputTarget = "Composite"
+
+	// OutputTargetCompositeAndClaim sets the condition and results on the
+	// composite resource and its claim.
+	OutputTargetCompositeAndClaim OutputTarget = "CompositeAndClaim"
+)
+
+// An OutputEmit determines how the status of the composition is reported.
+type OutputEmit string
+
+// What to emit.
+const (
+	// OutputEmitConditionsAndResults reports the status as a condition
+	// and as results.
+	OutputEmitConditionsAndResults OutputEmit = "ConditionsAndResults"
+
+	// OutputEmitConditions reports the status as a condition only.
+	OutputEmitConditions OutputEmit = "Conditions"
+
+	// OutputEmitResults reports the status as results only.
+	OutputEmitResults OutputEmit = "Results"
+)
+
+// Limits bound how much work Claude may do to analyze the composition. When a
+// limit is reached the analysis stops and the previous status is kept.
+type Limits struct {
+	// MaxToolRoundTrips is the maximum number of times Claude may be
+	// messaged, e.g. to retry an invalid status.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	MaxToolRoundTrips *int `json:"maxToolRoundTrips,omitempty"`
+
+	// MaxOutputTokens is the maximum number of tokens Claude may generate,
+	// summed across all of its responses.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	MaxOutputTokens *int64 `json:"maxOutputTokens,omitempty"`
+
+	// MaxPromptTokens is the approximate maximum number of tokens the
+	// prompt may use. Larger prompts are trimmed, dropping noisy fields and
+	// then details of healthy resources, until they fit.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	MaxPromptTokens *int64 `json:"maxPromptTokens,omitempty"`
+
+	// Timeout is the maximum amount of time the analysis may take.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
 }
 
 // AWS specifies configurations for working with AWS and ulimately Bedrock.
@@ -65,6 +287,132 @@ type Bedrock struct {
 	// ModelID is the Claude model to be used.
 	// +kubebuilder:default="us.anthropic.claude-sonnet-4-20250514-v1:0"
 	ModelID string `json:"modelID,omitempty"`
+
+	// TriageModelID is the Claude model that triages the composition when
+	// routing is Triage.
+	// +optional
+	// +kubebuilder:default="us.anthropic.claude-3-5-haiku-20241022-v1:0"
+	TriageModelID string `json:"triageModelID,omitempty"`
+}
+
+// Anthropic provides configurations for working with Anthropic's API as a
+// model provider.
+type Anthropic struct {
+	// Model is the Claude model to be used.
+	// +optional
+	// +kubebuilder:default="claude-sonnet-4-0"
+	Model string `json:"model,omitempty"`
+
+	// TriageModel is the Claude model that triages the composition when
+	// routing is Triage.
+	// +optional
+	// +kubebuilder:default="claude-3-5-haiku-latest"
+	TriageModel string `json:"triageModel,omitempty"`
+
+	// MaxTokens is the maximum number of tokens Claude may generate in a
+	// single response. It may not exceed the model's maximum output tokens.
+	// Defaults to 1024.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	MaxTokens *int64 `json:"maxTokens,omitempty"`
+
+	// Temperature is the amount of randomness in Claude's responses, from 0
+	// to 1. Defaults to 0.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=1
+	Temperature *float64 `json:"temperature,omitempty"`
+
+	// TopK limits Claude to sampling from the K most likely tokens.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	TopK *int64 `json:"topK,omitempty"`
+}
+
+// Thinking configures Claude's extended thinking. Thinking requires a model
+// that supports it, and can't be combined with a temperature other than 1 or
+// with topK.
+type Thinking struct {
+	// BudgetTokens is the maximum number of tokens Claude may use to think
+	// in a single response. Thinking tokens count toward the
+	// maxOutputTokens limit.
+	// +kubebuilder:validation:Minimum=1024
+	BudgetTokens int64 `json:"budgetTokens"`
+}
+
+// Examples are example diagnoses, supplied inline or by a ConfigMap.
+type Examples struct {
+	// Items are examples supplied inline.
+	// +optional
+	Items []Example `json:"items,omitempty"`
+
+	// ConfigMapReference references a ConfigMap whose data values are each
+	// a YAML encoded Example. The ConfigMap must be labelled
+	// function-claude-status-transformer.fn.crossplane.io/examples=true.
+	// Requires the Function to be started with --enable-function-configs.
+	// +optional
+	ConfigMapReference *NamespacedReference `json:"configMapRef,omitempty"`
+}
+
+// An Example pairs a snippet of observed resources with the status Claude
+// should produce for them.
+type Example struct {
+	// Resources is a snippet of observed resources, e.g. a YAML manifest.
+	Resources string `json:"resources"`
+
+	// Status is the status Claude should produce for the resources. It must
+	// have the same shape as the input of the submit_status tool.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Type=object
+	Status runtime.RawExtension `json:"status"`
+}
+
+// NamespacedReference is a named and namespaced object reference.
+type NamespacedReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// Prompt customizes the prompt sent to Claude.
+type Prompt struct {
+	// System replaces or extends the system prompt, which tells Claude who
+	// it is, e.g. which teams own which kinds of resource.
+	// +optional
+	System *PromptTemplate `json:"system,omitempty"`
+
+	// Instructions replaces or extends the instructions that tell Claude
+	// how to analyze the composition.
+	// +optional
+	Instructions *PromptTemplate `json:"instructions,omitempty"`
+}
+
+// A PromptMode determines how a PromptTemplate is combined with the default
+// prompt.
+type PromptMode string
+
+// Prompt modes.
+const (
+	// PromptModeAppend appends the template to the default prompt.
+	PromptModeAppend PromptMode = "Append"
+
+	// PromptModeReplace replaces the default prompt with the template.
+	PromptModeReplace PromptMode = "Replace"
+)
+
+// A PromptTemplate is a Go text/template. It's rendered with the composite
+// resource (.Composite), a summary of the composed resources (.Composed), their
+// dependencies (.Dependencies), the last status (.LastStatus), and the
+// additional context (.Input).
+type PromptTemplate struct {
+	// Mode determines whether the template is appended to or replaces the
+	// default prompt.
+	// +optional
+	// +kubebuilder:validation:Enum=Append;Replace
+	// +kubebuilder:default=Append
+	Mode PromptMode `json:"mode,omitempty"`
+
+	// Template is the Go text/template to render.
+	Template string `json:"template"`
 }
 
 // Reference is a nameed object reference.
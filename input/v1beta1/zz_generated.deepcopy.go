@@ -21,6 +21,7 @@ limitations under the License.
 package v1beta1
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -45,6 +46,36 @@ func (in *AWS) DeepCopy() *AWS {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Anthropic) DeepCopyInto(out *Anthropic) {
+	*out = *in
+	if in.MaxTokens != nil {
+		in, out := &in.MaxTokens, &out.MaxTokens
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Temperature != nil {
+		in, out := &in.Temperature, &out.Temperature
+		*out = new(float64)
+		**out = **in
+	}
+	if in.TopK != nil {
+		in, out := &in.TopK, &out.TopK
+		*out = new(int64)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Anthropic.
+func (in *Anthropic) DeepCopy() *Anthropic {
+	if in == nil {
+		return nil
+	}
+	out := new(Anthropic)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Bedrock) DeepCopyInto(out *Bedrock) {
 	*out = *in
@@ -60,6 +91,154 @@ func (in *Bedrock) DeepCopy() *Bedrock {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Example) DeepCopyInto(out *Example) {
+	*out = *in
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Example.
+func (in *Example) DeepCopy() *Example {
+	if in == nil {
+		return nil
+	}
+	out := new(Example)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Examples) DeepCopyInto(out *Examples) {
+	*out = *in
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Example, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ConfigMapReference != nil {
+		in, out := &in.ConfigMapReference, &out.ConfigMapReference
+		*out = new(NamespacedReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Examples.
+func (in *Examples) DeepCopy() *Examples {
+	if in == nil {
+		return nil
+	}
+	out := new(Examples)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Limits) DeepCopyInto(out *Limits) {
+	*out = *in
+	if in.MaxToolRoundTrips != nil {
+		in, out := &in.MaxToolRoundTrips, &out.MaxToolRoundTrips
+		*out = new(int)
+		**out = **in
+	}
+	if in.MaxOutputTokens != nil {
+		in, out := &in.MaxOutputTokens, &out.MaxOutputTokens
+		*out = new(int64)
+		**out = **in
+	}
+	if in.MaxPromptTokens != nil {
+		in, out := &in.MaxPromptTokens, &out.MaxPromptTokens
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Limits.
+func (in *Limits) DeepCopy() *Limits {
+	if in == nil {
+		return nil
+	}
+	out := new(Limits)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespacedReference) DeepCopyInto(out *NamespacedReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacedReference.
+func (in *NamespacedReference) DeepCopy() *NamespacedReference {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespacedReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Output) DeepCopyInto(out *Output) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Output.
+func (in *Output) DeepCopy() *Output {
+	if in == nil {
+		return nil
+	}
+	out := new(Output)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Prompt) DeepCopyInto(out *Prompt) {
+	*out = *in
+	if in.System != nil {
+		in, out := &in.System, &out.System
+		*out = new(PromptTemplate)
+		**out = **in
+	}
+	if in.Instructions != nil {
+		in, out := &in.Instructions, &out.Instructions
+		*out = new(PromptTemplate)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Prompt.
+func (in *Prompt) DeepCopy() *Prompt {
+	if in == nil {
+		return nil
+	}
+	out := new(Prompt)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PromptTemplate) DeepCopyInto(out *PromptTemplate) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PromptTemplate.
+func (in *PromptTemplate) DeepCopy() *PromptTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(PromptTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Reference) DeepCopyInto(out *Reference) {
 	*out = *in
@@ -85,6 +264,46 @@ func (in *StatusTransformation) DeepCopyInto(out *StatusTransformation) {
 		*out = new(AWS)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Anthropic != nil {
+		in, out := &in.Anthropic, &out.Anthropic
+		*out = new(Anthropic)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Thinking != nil {
+		in, out := &in.Thinking, &out.Thinking
+		*out = new(Thinking)
+		**out = **in
+	}
+	if in.Prompt != nil {
+		in, out := &in.Prompt, &out.Prompt
+		*out = new(Prompt)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Examples != nil {
+		in, out := &in.Examples, &out.Examples
+		*out = new(Examples)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.FunctionConfigReference != nil {
+		in, out := &in.FunctionConfigReference, &out.FunctionConfigReference
+		*out = new(Reference)
+		**out = **in
+	}
+	if in.Limits != nil {
+		in, out := &in.Limits, &out.Limits
+		*out = new(Limits)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MinInterval != nil {
+		in, out := &in.MinInterval, &out.MinInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Output != nil {
+		in, out := &in.Output, &out.Output
+		*out = new(Output)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StatusTransformation.
@@ -104,3 +323,18 @@ func (in *StatusTransformation) DeepCopyObject() runtime.Object {
 	}
 	return nil
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Thinking) DeepCopyInto(out *Thinking) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Thinking.
+func (in *Thinking) DeepCopy() *Thinking {
+	if in == nil {
+		return nil
+	}
+	out := new(Thinking)
+	in.DeepCopyInto(out)
+	return out
+}
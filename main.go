@@ -20,10 +20,14 @@ package main
 import (
 	"context"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/alecthomas/kong"
 	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	kruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/utils/ptr"
@@ -57,8 +61,39 @@ type CLI struct {
 	MaxRecvMessageSize int    `help:"Maximum size of received messages in MB." default:"4"`
 
 	EnableFunctionConfigs bool `help:"Enable support for FunctionConfig APIs."`
+	EnableListEventsTool  bool `help:"Let Claude list the Kubernetes events of composite and composed resources. Requires --enable-function-configs, and RBAC to get, list, and watch events."`
+
+	MaxToolRoundTrips int           `help:"Default maximum number of times Claude may be messaged per analysis. Overridden by the Function's input." default:"5"`
+	MaxOutputTokens   int64         `help:"Default maximum number of tokens Claude may generate per analysis. Overridden by the Function's input." default:"4096"`
+	MaxPromptTokens   int64         `help:"Default approximate maximum number of tokens a prompt may use before it's trimmed. Overridden by the Function's input." default:"50000"`
+	AnalysisTimeout   time.Duration `help:"Default maximum amount of time an analysis may take. Overridden by the Function's input." default:"2m"`
+
+	MaxConcurrentLLMCalls int           `help:"Maximum number of messages that may be sent to Claude at once. Zero is unlimited." default:"0"`
+	LLMRequestsPerMinute  int           `help:"Maximum number of messages that may be sent to Claude per minute. Zero is unlimited." default:"0"`
+	LLMTokensPerMinute    int           `help:"Approximate maximum number of tokens that may be sent to Claude per minute. Zero is unlimited." default:"0"`
+	LLMQueueTimeout       time.Duration `help:"Maximum amount of time a message may wait to be sent to Claude before its analysis is deferred to the next reconcile." default:"30s"`
+
+	LLMMaxRetries              int           `help:"Maximum number of times a message to Claude that fails with a transient error may be retried." default:"3"`
+	LLMRetryBaseBackoff        time.Duration `help:"How long to wait before retrying a message to Claude for the first time. Doubles with each retry." default:"1s"`
+	LLMRetryMaxBackoff         time.Duration `help:"Maximum amount of time to wait between retries, unless the provider asks to wait longer." default:"30s"`
+	LLMCircuitBreakerThreshold int           `help:"Number of consecutive analyses that fail with transient errors after which no messages are sent to a provider until it cools down. Zero disables the circuit breaker." default:"5"`
+	LLMCircuitBreakerCooldown  time.Duration `help:"How long to stop sending messages to a provider after its circuit breaker opens." default:"1m"`
+
+	ResponseCacheSize           int           `help:"Maximum number of diagnoses to cache and reuse for compositions that fail for the same reason. Zero disables the cache." default:"0"`
+	ResponseCacheTTL            time.Duration `help:"Maximum amount of time a cached diagnosis may be reused." default:"10m"`
+	ResponseCacheMaskIdentities bool          `help:"Ignore the names and UIDs of resources when determining whether compositions fail for the same reason." default:"true" negatable:""`
+	ResponseCacheBackend        string        `help:"Where to cache diagnoses. The bolt backend persists them to --response-cache-path. The configmap backend persists them to --response-cache-configmap, and requires --enable-function-configs." enum:"memory,bolt,configmap" default:"memory"`
+	ResponseCachePath           string        `help:"Path of the bbolt file used by the bolt response cache backend, e.g. on a persistent volume." default:"/var/cache/function-claude-status-transformer/diagnoses.db"`
+	ResponseCacheConfigMap      string        `help:"Namespace and name of the ConfigMap used by the configmap response cache backend, as namespace/name." default:"crossplane-system/function-claude-status-transformer-diagnoses"`
 }
 
+// Response cache backends.
+const (
+	cacheBackendMemory    = "memory"
+	cacheBackendBolt      = "bolt"
+	cacheBackendConfigMap = "configmap"
+)
+
 // Run this Function.
 func (c *CLI) Run() error {
 	log, err := function.NewLogger(c.Debug)
@@ -67,7 +102,49 @@ func (c *CLI) Run() error {
 	}
 	g, ctx := errgroup.WithContext(context.Background())
 
-	opts := []Option{}
+	if c.EnableListEventsTool && !c.EnableFunctionConfigs {
+		return errors.New("--enable-list-events-tool requires --enable-function-configs")
+	}
+	if c.ResponseCacheBackend == cacheBackendConfigMap && !c.EnableFunctionConfigs {
+		return errors.New("--response-cache-backend=configmap requires --enable-function-configs")
+	}
+
+	var backend CacheBackend
+	if c.ResponseCacheSize > 0 {
+		switch c.ResponseCacheBackend {
+		case cacheBackendMemory:
+			backend = NewMemoryCacheBackend(c.ResponseCacheSize)
+		case cacheBackendBolt:
+			b, err := NewBoltCacheBackend(c.ResponseCachePath, c.ResponseCacheSize)
+			if err != nil {
+				return errors.Wrap(err, "cannot open response cache")
+			}
+			defer b.Close() //nolint:errcheck // The Function is exiting.
+			backend = b
+		}
+	}
+
+	opts := []Option{
+		WithLimits(Limits{
+			MaxToolRoundTrips: c.MaxToolRoundTrips,
+			MaxOutputTokens:   c.MaxOutputTokens,
+			MaxPromptTokens:   c.MaxPromptTokens,
+			Timeout:           c.AnalysisTimeout,
+		}),
+		WithThrottle(Throttle{
+			MaxConcurrentCalls: c.MaxConcurrentLLMCalls,
+			RequestsPerMinute:  c.LLMRequestsPerMinute,
+			TokensPerMinute:    c.LLMTokensPerMinute,
+			QueueTimeout:       c.LLMQueueTimeout,
+		}),
+		WithRetry(Retry{
+			MaxRetries:       c.LLMMaxRetries,
+			BaseBackoff:      c.LLMRetryBaseBackoff,
+			MaxBackoff:       c.LLMRetryMaxBackoff,
+			BreakerThreshold: c.LLMCircuitBreakerThreshold,
+			BreakerCooldown:  c.LLMCircuitBreakerCooldown,
+		}),
+	}
 	if c.EnableFunctionConfigs {
 		// We want to use FunctionConfigs, we need to setup our client to
 		// ensure we don't unnecessarily hit the api-server.
@@ -76,28 +153,55 @@ func (c *CLI) Run() error {
 			return errors.Wrap(err, "failed to get the kubeconfig for the FunctionConfig manager")
 		}
 
+		byObject := map[client.Object]cache.ByObject{
+			&v1alpha1.FunctionConfig{}: {},
+			// Only cache the ConfigMaps that supply examples, not
+			// every ConfigMap in the cluster.
+			&corev1.ConfigMap{}: {Label: labels.SelectorFromSet(labels.Set{LabelExamples: "true"})},
+		}
+		if c.EnableListEventsTool {
+			// Events are numerous, so don't waste memory caching
+			// their managed fields.
+			byObject[&corev1.Event{}] = cache.ByObject{Transform: cache.TransformStripManagedFields()}
+		}
+
 		mgr, err := ctrl.NewManager(cfg, ctrl.Options{
 			Cache: cache.Options{
 				// TODO(tnthornton): expose the SyncInterval as a toggle if we
 				// find this to be a feature we want to keep.
 				SyncPeriod: ptr.To(time.Hour),
-				ByObject: map[client.Object]cache.ByObject{
-					&v1alpha1.FunctionConfig{}: {},
-				},
+				ByObject:   byObject,
 			},
 		})
 		if err != nil {
 			return errors.Wrap(err, "failed to setup FunctionConfig manager")
 		}
 
+		if c.EnableListEventsTool {
+			if err := mgr.GetFieldIndexer().IndexField(ctx, &corev1.Event{}, eventInvolvedObjectUIDField, IndexEventByInvolvedObjectUID); err != nil {
+				return errors.Wrap(err, "failed to index events")
+			}
+			opts = append(opts, WithListEventsTool())
+		}
+
 		g.Go(func() error {
 			err := mgr.Start(ctx)
 			return errors.Wrap(ignoreCanceled(err), "failed to start manager")
 		})
 
-		opts = append(opts, WithClient(mgr.GetClient()))
+		opts = append(opts, WithClient(mgr.GetClient()), WithAPIReader(mgr.GetAPIReader()))
+
+		if c.ResponseCacheSize > 0 && c.ResponseCacheBackend == cacheBackendConfigMap {
+			ns, name, ok := strings.Cut(c.ResponseCacheConfigMap, "/")
+			if !ok || ns == "" || name == "" {
+				return errors.Errorf("--response-cache-configmap must be namespace/name, not %q", c.ResponseCacheConfigMap)
+			}
+			backend = NewConfigMapCacheBackend(mgr.GetAPIReader(), mgr.GetClient(), types.NamespacedName{Namespace: ns, Name: name}, c.ResponseCacheSize)
+		}
 	}
 
+	opts = append(opts, WithResponseCache(backend, c.ResponseCacheTTL, c.ResponseCacheMaskIdentities))
+
 	return function.Serve(NewFunction(log, opts...),
 		function.Listen(c.Network, c.Address),
 		function.MTLSCertificates(c.TLSCertsDir),
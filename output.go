@@ -0,0 +1,301 @@
+/*
+Copyright 2025 The Upbound Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"k8s.io/utils/ptr"
+
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/fieldpath"
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+	"github.com/crossplane/function-sdk-go/response"
+
+	"github.com/upbound/function-claude-status-transformer/input/v1beta1"
+)
+
+// defaultStatusPath is the field path of the composite resource the status of
+// the composition is written to in Status output mode.
+const defaultStatusPath = "status.claude"
+
+// reasonSuggestedFix is the reason of a result that suggests how to fix a
+// composed resource.
+const reasonSuggestedFix = "SuggestedFix"
+
+// conditionTypePattern matches valid configured condition types.
+var conditionTypePattern = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+
+// statusPath returns the field path of the composite resource the status of
+// the composition is written to in Status output mode.
+func statusPath(in *v1beta1.StatusTransformation) string {
+	if in.Output == nil || in.Output.StatusPath == "" {
+		return defaultStatusPath
+	}
+	return in.Output.StatusPath
+}
+
+// An output reports the status of the composition, as configured by the
+// Function's input.
+type output struct {
+	// path of the composite resource's status the status of the
+	// composition is written to in Status output mode.
+	path string
+
+	// status is true in Status output mode.
+	status bool
+
+	// suggestions is true if each suggested fix should be emitted as a
+	// separate result.
+	suggestions bool
+
+	// conditions and results are true if the status should be emitted as
+	// a condition and as results respectively.
+	conditions, results bool
+
+	// healthyType is the type of the condition that represents the
+	// status. fingerprintType and analyzedType are the types of the
+	// conditions that record the analysis.
+	healthyType, fingerprintType, analyzedType xpv1.ConditionType
+
+	// target of the condition and results that represent the status. Nil
+	// targets the composite resource only.
+	target *fnv1.Target
+}
+
+// newOutput returns the output configured by the supplied input. It returns
+// an error if the input is invalid.
+func newOutput(in *v1beta1.StatusTransformation) (output, error) {
+	o := output{
+		path:            statusPath(in),
+		conditions:      true,
+		results:         true,
+		healthyType:     conditionTypeClaudeHealthy,
+		fingerprintType: conditionTypeClaudeFingerprint,
+		analyzedType:    conditionTypeClaudeAnalyzed,
+	}
+	if in.Output == nil {
+		return o, nil
+	}
+	o.suggestions = in.Output.SuggestionResults
+
+	if in.Output.Mode == v1beta1.OutputModeStatus {
+		if !strings.HasPrefix(o.path, "status.") || len(o.path) == len("status.") {
+			return output{}, errors.Errorf("output.statusPath must be a field path within the composite resource's status, not %q", o.path)
+		}
+		o.status = true
+	}
+
+	if t := xpv1.ConditionType(in.Output.ConditionType); t != "" && t != conditionTypeClaudeHealthy {
+		if !conditionTypePattern.MatchString(string(t)) || t == xpv1.TypeReady || t == xpv1.TypeSynced {
+			return output{}, errors.Errorf("output.conditionType must be a CamelCase condition type other than Ready and Synced, not %q", t)
+		}
+		o.healthyType = t
+		o.fingerprintType = t + "ObservedFingerprint"
+		o.analyzedType = t + "LastAnalyzed"
+	}
+
+	switch in.Output.Target {
+	case v1beta1.OutputTargetComposite, "":
+	case v1beta1.OutputTargetCompositeAndClaim:
+		o.target = fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum()
+	default:
+		return output{}, errors.Errorf("unknown output.target %q", in.Output.Target)
+	}
+
+	switch in.Output.Emit {
+	case v1beta1.OutputEmitConditionsAndResults, "":
+	case v1beta1.OutputEmitConditions:
+		o.results = false
+	case v1beta1.OutputEmitResults:
+		// The last status is read back from the composite resource, and
+		// results aren't persisted.
+		if !o.status {
+			return output{}, errors.New("output.emit Results requires output.mode Status")
+		}
+		o.conditions = false
+	default:
+		return output{}, errors.Errorf("unknown output.emit %q", in.Output.Emit)
+	}
+
+	return o, nil
+}
+
+// Keep carries the last status Claude produced forward, so that a failed
+// analysis doesn't remove it from the XR. It does nothing if Claude hasn't
+// produced a status yet.
+func (o output) Keep(rsp *fnv1.RunFunctionResponse, last CompositionStatus) {
+	if last.Summary == "" {
+		return
+	}
+	if o.conditions {
+		rsp.Conditions = append(rsp.Conditions, o.condition(last))
+	}
+	rsp.Conditions = append(rsp.Conditions, o.analysisConditions(last)...)
+	o.write(rsp, last)
+}
+
+// Set sets the supplied status as the Function's condition and results.
+func (o output) Set(rsp *fnv1.RunFunctionResponse, status CompositionStatus) {
+	if o.conditions {
+		rsp.Conditions = append(rsp.Conditions, o.condition(status))
+	}
+	rsp.Conditions = append(rsp.Conditions, o.analysisConditions(status)...)
+	if o.results {
+		rsp.Results = append(rsp.Results, &fnv1.Result{
+			Severity: status.severity(),
+			Message:  status.Summary,
+			Reason:   ptr.To(status.reason()),
+			Target:   o.target,
+		})
+		if o.suggestions {
+			rsp.Results = append(rsp.Results, o.suggestionResults(status)...)
+		}
+	}
+	o.write(rsp, status)
+}
+
+// analysisConditions returns the conditions that record the analysis that
+// produced the supplied status. They're only set on the composite resource,
+// since they're bookkeeping.
+func (o output) analysisConditions(status CompositionStatus) []*fnv1.Condition {
+	conds := []*fnv1.Condition{}
+	if status.Fingerprint != "" {
+		conds = append(conds, fingerprintCondition(o.fingerprintType, status.Fingerprint))
+	}
+	if !status.AnalyzedAt.IsZero() {
+		conds = append(conds, &fnv1.Condition{
+			Type:    string(o.analyzedType),
+			Status:  fnv1.Status_STATUS_CONDITION_TRUE,
+			Reason:  reasonAnalyzed,
+			Message: ptr.To(status.AnalyzedAt.UTC().Format(time.RFC3339)),
+		})
+	}
+	return conds
+}
+
+// analyzedAt returns the analysis time recorded by the supplied response's
+// conditions, or the zero time if none is recorded.
+func (o output) analyzedAt(rsp *fnv1.RunFunctionResponse) time.Time {
+	for _, c := range rsp.GetConditions() {
+		if c.GetType() != string(o.analyzedType) {
+			continue
+		}
+		// We only record times we formatted, so this can't fail.
+		t, _ := time.Parse(time.RFC3339, c.GetMessage())
+		return t
+	}
+	return time.Time{}
+}
+
+// suggestionResults returns a Normal result for each fix suggested by the
+// supplied status.
+func (o output) suggestionResults(status CompositionStatus) []*fnv1.Result {
+	results := []*fnv1.Result{}
+	for _, rs := range status.ResourceStatuses {
+		if rs.SuggestedFix == "" {
+			continue
+		}
+		msg := fmt.Sprintf("To fix %s %s: %s", rs.Kind, rs.Name, rs.SuggestedFix)
+		if rs.DocsURL != "" {
+			msg += fmt.Sprintf(" (see %s)", rs.DocsURL)
+		}
+		results = append(results, &fnv1.Result{
+			Severity: fnv1.Severity_SEVERITY_NORMAL,
+			Message:  msg,
+			Reason:   ptr.To(reasonSuggestedFix),
+			Target:   o.target,
+		})
+	}
+	return results
+}
+
+// Copy copies the status written to the desired composite resource of one
+// response to that of another.
+func (o output) Copy(from, to *fnv1.RunFunctionResponse) {
+	if !o.status {
+		return
+	}
+	v, err := fieldpath.Pave(from.GetDesired().GetComposite().GetResource().AsMap()).GetValue(o.path)
+	if err != nil {
+		// Nothing was written.
+		return
+	}
+	o.write(to, v)
+}
+
+// condition returns the condition that represents the supplied status. Its
+// reason is a short CamelCase reason, except in Reason output mode. That mode
+// has nowhere else to keep the status of each composed resource, so the
+// reason is their JSON encoded statuses.
+func (o output) condition(status CompositionStatus) *fnv1.Condition {
+	reason := status.reason()
+	if !o.status {
+		// Marshalling a slice of structs with only string and bool
+		// fields can't fail.
+		j, _ := json.Marshal(status.ResourceStatuses)
+		reason = string(j)
+	}
+
+	cond := &fnv1.Condition{
+		Type:    string(o.healthyType),
+		Message: ptr.To(status.Summary),
+		Reason:  reason,
+		Target:  o.target,
+	}
+
+	if status.OverallStatus == overallStatusReady {
+		cond.Status = fnv1.Status_STATUS_CONDITION_TRUE
+	} else {
+		cond.Status = fnv1.Status_STATUS_CONDITION_FALSE
+	}
+
+	return cond
+}
+
+// write writes the supplied value to the desired composite resource's status.
+// It does nothing in Reason output mode.
+func (o output) write(rsp *fnv1.RunFunctionResponse, v any) {
+	if !o.status {
+		return
+	}
+
+	dxr := &resource.Composite{
+		Resource:          composite.New(),
+		ConnectionDetails: rsp.GetDesired().GetComposite().GetConnectionDetails(),
+	}
+	if s := rsp.GetDesired().GetComposite().GetResource(); s != nil {
+		if err := resource.AsObject(s, dxr.Resource); err != nil {
+			response.Warning(rsp, errors.Wrap(err, "cannot get desired composite resource"))
+			return
+		}
+	}
+	if err := dxr.Resource.SetValue(o.path, v); err != nil {
+		response.Warning(rsp, errors.Wrapf(err, "cannot write status to %s", o.path))
+		return
+	}
+	if err := response.SetDesiredCompositeResource(rsp, dxr); err != nil {
+		response.Warning(rsp, errors.Wrap(err, "cannot set desired composite resource"))
+	}
+}
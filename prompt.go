@@ -0,0 +1,119 @@
+/*
+Copyright 2025 The Upbound Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/crossplane/function-sdk-go/errors"
+
+	"github.com/upbound/function-claude-status-transformer/input/v1alpha1"
+	"github.com/upbound/function-claude-status-transformer/input/v1beta1"
+)
+
+// A Prompt is the system prompt and instructions sent to Claude.
+type Prompt struct {
+	System       string
+	Instructions string
+}
+
+// getFunctionConfig returns the FunctionConfig referenced by the supplied
+// input, or nil if it doesn't reference one.
+func (f *Function) getFunctionConfig(ctx context.Context, in *v1beta1.StatusTransformation) (*v1alpha1.FunctionConfig, error) {
+	if in.FunctionConfigReference == nil {
+		return nil, nil
+	}
+	if f.c == nil {
+		return nil, errors.New("functionConfigRef requires the Function to be started with --enable-function-configs")
+	}
+	fc := &v1alpha1.FunctionConfig{}
+	if err := f.c.Get(ctx, types.NamespacedName{Name: in.FunctionConfigReference.Name}, fc); err != nil {
+		return nil, errors.Wrapf(err, "cannot get FunctionConfig %q", in.FunctionConfigReference.Name)
+	}
+	return fc, nil
+}
+
+// promptTemplates returns the prompt customizations that apply to the
+// supplied input. Customizations in the input take precedence over those in
+// the FunctionConfig.
+func promptTemplates(in *v1beta1.StatusTransformation, fc *v1alpha1.FunctionConfig) (system, instructions *v1beta1.PromptTemplate) {
+	if fc != nil && fc.Spec.Prompt != nil {
+		system = fromFunctionConfig(fc.Spec.Prompt.System)
+		instructions = fromFunctionConfig(fc.Spec.Prompt.Instructions)
+	}
+	if in.Prompt != nil {
+		if in.Prompt.System != nil {
+			system = in.Prompt.System
+		}
+		if in.Prompt.Instructions != nil {
+			instructions = in.Prompt.Instructions
+		}
+	}
+	return system, instructions
+}
+
+func fromFunctionConfig(t *v1alpha1.PromptTemplate) *v1beta1.PromptTemplate {
+	if t == nil {
+		return nil
+	}
+	return &v1beta1.PromptTemplate{Mode: v1beta1.PromptMode(t.Mode), Template: t.Template}
+}
+
+// buildPrompt builds the prompt for the supplied input, rendering any
+// customized templates with the supplied variables.
+func buildPrompt(in *v1beta1.StatusTransformation, fc *v1alpha1.FunctionConfig, v *Variables) (Prompt, error) {
+	st, it := promptTemplates(in, fc)
+
+	s, err := renderPromptTemplate("system", system, st, v)
+	if err != nil {
+		return Prompt{}, err
+	}
+
+	i, err := renderPromptTemplate("instructions", prompt, it, v)
+	if err != nil {
+		return Prompt{}, err
+	}
+
+	return Prompt{System: s, Instructions: i}, nil
+}
+
+// renderPromptTemplate renders the supplied template and combines it with the
+// default prompt according to the template's mode.
+func renderPromptTemplate(name, def string, t *v1beta1.PromptTemplate, v *Variables) (string, error) {
+	if t == nil {
+		return def, nil
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(t.Template)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot parse %s prompt template", name)
+	}
+
+	b := &strings.Builder{}
+	if err := tmpl.Execute(b, v); err != nil {
+		return "", errors.Wrapf(err, "cannot render %s prompt template", name)
+	}
+
+	if t.Mode == v1beta1.PromptModeReplace {
+		return b.String(), nil
+	}
+	return def + "\n" + b.String(), nil
+}
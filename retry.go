@@ -0,0 +1,426 @@
+/*
+Copyright 2025 The Upbound Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	"github.com/crossplane/function-sdk-go/logging"
+)
+
+// Default retry and circuit breaker configuration.
+const (
+	defaultMaxRetries       = 3
+	defaultBaseBackoff      = time.Second
+	defaultMaxBackoff       = 30 * time.Second
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = time.Minute
+)
+
+// Providers of Claude.
+const (
+	providerAnthropic = "anthropic"
+	providerBedrock   = "bedrock"
+)
+
+// statusOverloaded is the HTTP status Anthropic returns when its API is
+// overloaded.
+const statusOverloaded = 529
+
+// An errorClass classifies an error returned when messaging Claude.
+type errorClass string
+
+// Classes of error.
+const (
+	// errorClassThrottled errors are returned when a rate limit is
+	// exceeded.
+	errorClassThrottled errorClass = "Throttled"
+
+	// errorClassOverloaded errors are returned when Anthropic's API is
+	// temporarily overloaded.
+	errorClassOverloaded errorClass = "Overloaded"
+
+	// errorClassServer errors are returned when the provider fails to
+	// handle a valid request.
+	errorClassServer errorClass = "ServerError"
+
+	// errorClassNetwork errors are returned when the provider can't be
+	// reached.
+	errorClassNetwork errorClass = "NetworkError"
+
+	// errorClassAuth errors are returned when the credentials are invalid
+	// or lack permission.
+	errorClassAuth errorClass = "AuthError"
+
+	// errorClassInvalid errors are returned when the request is invalid.
+	errorClassInvalid errorClass = "InvalidRequest"
+
+	// errorClassClient errors are returned before a request reaches the
+	// provider, e.g. because credentials can't be loaded or the request
+	// can't be built.
+	errorClassClient errorClass = "ClientError"
+)
+
+// transient returns true if a message that failed with this class of error may
+// succeed if it's retried.
+func (c errorClass) transient() bool {
+	switch c {
+	case errorClassThrottled, errorClassOverloaded, errorClassServer, errorClassNetwork:
+		return true
+	case errorClassAuth, errorClassInvalid, errorClassClient:
+		return false
+	}
+	return false
+}
+
+// classifyError returns the class of the supplied error, which was returned
+// when messaging Claude.
+func classifyError(err error) errorClass {
+	apiErr := &anthropic.Error{}
+	if !errors.As(err, &apiErr) {
+		return classifyClientError(err)
+	}
+	switch code := apiErr.StatusCode; {
+	case code == http.StatusTooManyRequests:
+		return errorClassThrottled
+	case code == statusOverloaded:
+		return errorClassOverloaded
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return errorClassAuth
+	case code == http.StatusRequestTimeout || code >= http.StatusInternalServerError:
+		return errorClassServer
+	default:
+		return errorClassInvalid
+	}
+}
+
+// classifyClientError returns the class of the supplied error, which was
+// returned without a response from the provider. Only errors that show the
+// provider couldn't be reached are network errors.
+func classifyClientError(err error) errorClass {
+	opErr := &net.OpError{}
+	var netErr net.Error
+	switch {
+	case errors.As(err, &opErr):
+		return errorClassNetwork
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return errorClassNetwork
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET):
+		return errorClassNetwork
+	default:
+		return errorClassClient
+	}
+}
+
+// retryAfter returns how long the provider asked us to wait before retrying
+// the message that returned the supplied error, or zero if it didn't ask.
+func retryAfter(err error) time.Duration {
+	apiErr := &anthropic.Error{}
+	if !errors.As(err, &apiErr) || apiErr.Response == nil {
+		return 0
+	}
+	h := apiErr.Response.Header
+	if ms, err := strconv.ParseFloat(h.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	v := h.Get("Retry-After")
+	if s, err := strconv.ParseFloat(v, 64); err == nil && s > 0 {
+		return time.Duration(s * float64(time.Second))
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// Retry configures how messages to Claude are retried when they fail with a
+// transient error, and when a provider's circuit breaker opens.
+type Retry struct {
+	// MaxRetries is the maximum number of times a message may be retried.
+	MaxRetries int
+
+	// BaseBackoff is how long to wait before the first retry. Each retry
+	// waits twice as long as the last, with jitter, unless the provider
+	// asks us to wait longer.
+	BaseBackoff time.Duration
+
+	// MaxBackoff is the longest to wait between retries, unless the
+	// provider asks us to wait longer.
+	MaxBackoff time.Duration
+
+	// BreakerThreshold is the number of consecutive messages that fail
+	// with transient errors, after retrying, after which a provider's
+	// circuit breaker opens. Zero disables the breaker.
+	BreakerThreshold int
+
+	// BreakerCooldown is how long a provider's circuit breaker stays open
+	// before a message may be sent to test whether it has recovered.
+	BreakerCooldown time.Duration
+}
+
+// DefaultRetry is the default Retry configuration of a Function.
+func DefaultRetry() Retry {
+	return Retry{
+		MaxRetries:       defaultMaxRetries,
+		BaseBackoff:      defaultBaseBackoff,
+		MaxBackoff:       defaultMaxBackoff,
+		BreakerThreshold: defaultBreakerThreshold,
+		BreakerCooldown:  defaultBreakerCooldown,
+	}
+}
+
+// backoff returns how long to wait before the supplied retry, which starts
+// at zero. It waits at least as long as the provider asked.
+func (r Retry) backoff(retry int, after time.Duration) time.Duration {
+	d := r.MaxBackoff
+	if retry < 32 {
+		d = min(r.BaseBackoff<<retry, r.MaxBackoff)
+	}
+	// Full jitter spreads out retries by analyses that failed together.
+	if d > 0 {
+		d = d/2 + rand.N(d/2+1) //nolint:gosec // Jitter needn't be cryptographically secure.
+	}
+	return max(d, after)
+}
+
+// Possible states of a circuit breaker, as reported by the
+// circuitBreakerState metric.
+const (
+	breakerClosed   = 0
+	breakerHalfOpen = 1
+	breakerOpen     = 2
+)
+
+var (
+	// errorsTotal counts the errors returned when messaging Claude.
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "function_claude_llm_errors_total",
+		Help: "Total number of errors returned when messaging Claude, by provider and class of error.",
+	}, []string{"provider", "class"})
+
+	// retriesTotal counts the messages to Claude that were retried.
+	retriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "function_claude_llm_retries_total",
+		Help: "Total number of messages to Claude that were retried, by provider.",
+	}, []string{"provider"})
+
+	// circuitBreakerState is the state of each provider's circuit breaker.
+	circuitBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "function_claude_llm_circuit_breaker_state",
+		Help: "State of each provider's circuit breaker: 0 is closed, 1 is half-open, and 2 is open.",
+	}, []string{"provider"})
+)
+
+//nolint:gochecknoinits // Metrics must be registered before they're served.
+func init() {
+	metrics.Registry.MustRegister(errorsTotal, retriesTotal, circuitBreakerState)
+}
+
+// A breaker stops messages from being sent to a provider that keeps failing,
+// so that a broken provider doesn't add latency to every analysis.
+type breaker struct {
+	provider string
+	log      logging.Logger
+
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+
+	// probing is true while a half-open breaker is testing whether the
+	// provider has recovered.
+	probing bool
+}
+
+// Allow returns an error if a message may not be sent to the provider.
+func (b *breaker) Allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		if until := b.openedAt.Add(b.cooldown); b.now().Before(until) {
+			return errors.Errorf("circuit breaker for %s is open until %s after %d consecutive errors", b.provider, until.UTC().Format(time.RFC3339), b.failures)
+		}
+		b.setState(breakerHalfOpen)
+	}
+
+	// Only one message tests whether the provider has recovered.
+	if b.state == breakerHalfOpen {
+		if b.probing {
+			return errors.Errorf("circuit breaker for %s is half-open and testing whether it has recovered", b.provider)
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Record the result of a message sent to the provider.
+func (b *breaker) Record(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	// The message never reached the provider, so it says nothing about
+	// the provider's health.
+	if err != nil && classifyError(err) == errorClassClient {
+		return
+	}
+
+	// The provider responded, even if it rejected the message.
+	if err == nil || !classifyError(err).transient() {
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.now()
+		b.setState(breakerOpen)
+	}
+}
+
+// Abandon a message sent to the provider without recording its result.
+func (b *breaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// setState sets the state of the breaker, logging and reporting changes. The
+// caller must hold the lock.
+func (b *breaker) setState(s int) {
+	circuitBreakerState.WithLabelValues(b.provider).Set(float64(s))
+	if s == b.state {
+		return
+	}
+	b.state = s
+	switch s {
+	case breakerOpen:
+		b.log.Info("Opened circuit breaker", "provider", b.provider, "consecutiveErrors", b.failures, "cooldown", b.cooldown)
+	case breakerHalfOpen:
+		b.log.Info("Half-opened circuit breaker to test whether provider has recovered", "provider", b.provider)
+	case breakerClosed:
+		b.log.Info("Closed circuit breaker", "provider", b.provider)
+	}
+}
+
+// A retrier retries messages to Claude that fail with transient errors, and
+// breaks the circuit to providers that keep failing. It's shared by all calls
+// to the Function.
+type retrier struct {
+	cfg Retry
+	log logging.Logger
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+func newRetrier(log logging.Logger, r Retry) *retrier {
+	return &retrier{cfg: r, log: log, breakers: make(map[string]*breaker)}
+}
+
+// breaker returns the circuit breaker of the supplied provider.
+func (r *retrier) breaker(provider string) *breaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.breakers[provider]
+	if !ok {
+		b = &breaker{provider: provider, log: r.log, threshold: r.cfg.BreakerThreshold, cooldown: r.cfg.BreakerCooldown, now: time.Now}
+		r.breakers[provider] = b
+	}
+	return b
+}
+
+// New sends the supplied message to Claude using the supplied client,
+// retrying transient errors. It returns an error without sending the message
+// if the provider's circuit breaker is open. The breaker records one result per
+// message, not per retry. A message that fails ends its analysis, so each
+// failed analysis counts once toward opening the breaker.
+func (r *retrier) New(ctx context.Context, provider string, c MessageClient, params anthropic.MessageNewParams) (*anthropic.Message, error) {
+	b := r.breaker(provider)
+	if err := b.Allow(); err != nil {
+		return nil, err
+	}
+
+	for retry := 0; ; retry++ {
+		m, err := c.New(ctx, params)
+
+		// A cancelled message says nothing about the provider's health.
+		if err != nil && ctx.Err() != nil {
+			b.Abandon()
+			return nil, err
+		}
+		if err == nil {
+			b.Record(nil)
+			return m, nil
+		}
+
+		class := classifyError(err)
+		errorsTotal.WithLabelValues(provider, string(class)).Inc()
+		err = errors.Wrapf(err, "%s from %s", class, provider)
+		if !class.transient() || retry >= r.cfg.MaxRetries {
+			b.Record(err)
+			return nil, err
+		}
+
+		// Don't wait for a retry that can't finish in time.
+		wait := r.cfg.backoff(retry, retryAfter(err))
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			b.Record(err)
+			return nil, errors.Wrapf(err, "cannot retry within %s", time.Until(deadline).Round(time.Second))
+		}
+
+		r.log.Debug("Retrying message to Claude", "provider", provider, "class", class, "retry", retry+1, "wait", wait, "error", err)
+		retriesTotal.WithLabelValues(provider).Inc()
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			b.Abandon()
+			return nil, err
+		case <-t.C:
+		}
+	}
+}
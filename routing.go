@@ -0,0 +1,149 @@
+/*
+Copyright 2025 The Upbound Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/tidwall/gjson"
+
+	"github.com/crossplane/function-sdk-go/errors"
+
+	"github.com/upbound/function-claude-status-transformer/input/v1beta1"
+)
+
+// Default triage models, used when the input doesn't configure them.
+const (
+	defaultAWSBedrockTriageModel = "us.anthropic.claude-3-5-haiku-20241022-v1:0"
+	defaultAnthropicTriageModel  = anthropic.ModelClaude3_5HaikuLatest
+)
+
+// Tiers of model that may diagnose a composition when routing is Triage.
+const (
+	// tierTriage compositions are diagnosed by the triage model.
+	tierTriage = "Triage"
+
+	// tierEscalated compositions are diagnosed by the configured model.
+	tierEscalated = "Escalated"
+)
+
+// Possible complexities of a composition, as classified by the triage model.
+const (
+	complexityHealthy = "Healthy"
+	complexitySimple  = "Simple"
+	complexityComplex = "Complex"
+)
+
+const triagePrompt = `
+Before the composition is analyzed in depth, triage it by calling the
+triage_composition tool. You can't fetch resources or list events while
+triaging, so only use the information above.
+
+- If no resources are unhealthy, set "complexity" to "Healthy" and include the
+  status.
+- If the cause of every failure is evident from the information above, set
+  "complexity" to "Simple" and include the status.
+- Otherwise set "complexity" to "Complex" and omit the status.
+`
+
+const (
+	triageToolName        = "triage_composition"
+	triageToolDescription = `
+Triages the composition, classifying how complex it is to diagnose. Includes
+the status of the composition unless it's complex to diagnose.
+`
+)
+
+// triageTool lets the triage model classify the composition.
+var triageTool = anthropic.ToolUnionParam{
+	OfTool: &anthropic.ToolParam{
+		Name:        triageToolName,
+		Description: anthropic.String(triageToolDescription),
+		InputSchema: anthropic.ToolInputSchemaParam{
+			Properties: map[string]any{
+				"complexity": map[string]any{
+					"type":        "string",
+					"enum":        []string{complexityHealthy, complexitySimple, complexityComplex},
+					"description": "How complex the composition is to diagnose.",
+				},
+				"status": map[string]any{
+					"type":                 "object",
+					"description":          "The status of the composition, in the shape supplied in the <example> tag. Omitted if the composition is complex to diagnose.",
+					"properties":           submitStatusToolInputSchema.Properties,
+					"required":             statusRequiredFields,
+					"additionalProperties": false,
+				},
+			},
+			Required: []string{"complexity"},
+			ExtraFields: map[string]any{
+				"additionalProperties": false,
+			},
+		},
+	},
+}
+
+// getTriageModel returns the anthropic.Model that should triage the incoming
+// request.
+func getTriageModel(in *v1beta1.StatusTransformation) anthropic.Model {
+	if in.UseAWS() {
+		if len(in.AWS.Bedrock.TriageModelID) == 0 {
+			return defaultAWSBedrockTriageModel
+		}
+		return anthropic.Model(in.AWS.Bedrock.TriageModelID)
+	}
+	if in.Anthropic == nil || len(in.Anthropic.TriageModel) == 0 {
+		return defaultAnthropicTriageModel
+	}
+	return anthropic.Model(in.Anthropic.TriageModel)
+}
+
+// triageStatus returns the status the triage model submitted in the supplied
+// message. It returns an error describing why the composition should be
+// escalated if the composition is complex, or if the triage model's response
+// is invalid.
+func triageStatus(m *anthropic.Message) (CompositionStatus, error) {
+	for _, block := range m.Content {
+		if b, ok := block.AsAny().(anthropic.ToolUseBlock); ok && b.Name == triageToolName {
+			return parseTriage(b.JSON.Input.Raw())
+		}
+	}
+	return CompositionStatus{}, errors.Errorf("triage model didn't call the %s tool (stop reason %q)", triageToolName, m.StopReason)
+}
+
+// parseTriage parses the input the triage model supplied to the
+// triage_composition tool.
+func parseTriage(input string) (CompositionStatus, error) {
+	switch c := gjson.Get(input, "complexity").String(); c {
+	case complexityHealthy, complexitySimple:
+	case complexityComplex:
+		return CompositionStatus{}, errors.New("triage model found the failure complex")
+	default:
+		return CompositionStatus{}, errors.Errorf("triage model returned unknown complexity %q", c)
+	}
+
+	raw := gjson.Get(input, "status")
+	if !raw.Exists() {
+		return CompositionStatus{}, errors.New("triage model didn't return a status")
+	}
+	status, err := parseStatus(raw.Raw)
+	return status, errors.Wrap(err, "triage model returned an invalid status")
+}
+
+// triageMessage returns the message that asks the triage model to triage the
+// composition.
+func triageMessage() anthropic.MessageParam {
+	return anthropic.NewUserMessage(anthropic.NewTextBlock(triagePrompt))
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2025 The Upbound Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
+
+	"github.com/crossplane/function-sdk-go/errors"
+)
+
+// defaultQueueTimeout is the default maximum amount of time a message to
+// Claude may wait to be sent.
+const defaultQueueTimeout = 30 * time.Second
+
+// Throttle bounds the messages sent to Claude across every call to the
+// Function, to avoid tripping Anthropic's or AWS Bedrock's rate limits. Zero
+// values are unlimited.
+type Throttle struct {
+	// MaxConcurrentCalls is the maximum number of messages that may be
+	// sent to Claude at once.
+	MaxConcurrentCalls int
+
+	// RequestsPerMinute is the maximum number of messages that may be sent
+	// to Claude per minute.
+	RequestsPerMinute int
+
+	// TokensPerMinute is the approximate maximum number of tokens that may
+	// be sent to Claude per minute.
+	TokensPerMinute int
+
+	// QueueTimeout is the maximum amount of time a message may wait to be
+	// sent before the analysis is deferred.
+	QueueTimeout time.Duration
+}
+
+// A throttle enforces a Throttle. It's shared by all calls to the Function.
+type throttle struct {
+	concurrent *semaphore.Weighted
+	requests   *rate.Limiter
+	tokens     *rate.Limiter
+	timeout    time.Duration
+}
+
+func newThrottle(t Throttle) *throttle {
+	th := &throttle{timeout: t.QueueTimeout}
+	if th.timeout <= 0 {
+		th.timeout = defaultQueueTimeout
+	}
+	if t.MaxConcurrentCalls > 0 {
+		th.concurrent = semaphore.NewWeighted(int64(t.MaxConcurrentCalls))
+	}
+	// Allow a minute's worth of requests or tokens at once, so that a
+	// quiet Function can respond to a burst of reconciles.
+	if t.RequestsPerMinute > 0 {
+		th.requests = rate.NewLimiter(rate.Limit(float64(t.RequestsPerMinute)/60), t.RequestsPerMinute)
+	}
+	if t.TokensPerMinute > 0 {
+		th.tokens = rate.NewLimiter(rate.Limit(float64(t.TokensPerMinute)/60), t.TokensPerMinute)
+	}
+	return th
+}
+
+// Acquire waits until a message estimated to use the supplied number of
+// tokens may be sent to Claude. It returns a function that must be called once
+// the message has been sent. It returns an error if the message can't be sent
+// within the queue timeout.
+func (t *throttle) Acquire(ctx context.Context, tokens int64) (func(), error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	// The rate limiters return an error immediately if they can't allow
+	// the message before the context's deadline.
+	if t.requests != nil {
+		if err := t.requests.Wait(ctx); err != nil {
+			return nil, errors.Wrapf(err, "too many requests per minute to send a message within %s", t.timeout)
+		}
+	}
+	if t.tokens != nil {
+		// A message larger than the burst would never be allowed.
+		n := int(min(tokens, int64(t.tokens.Burst())))
+		if err := t.tokens.WaitN(ctx, n); err != nil {
+			return nil, errors.Wrapf(err, "too many tokens per minute to send a message within %s", t.timeout)
+		}
+	}
+	if t.concurrent == nil {
+		return func() {}, nil
+	}
+	if err := t.concurrent.Acquire(ctx, 1); err != nil {
+		return nil, errors.Wrapf(err, "too many concurrent messages to send a message within %s", t.timeout)
+	}
+	return func() { t.concurrent.Release(1) }, nil
+}
@@ -0,0 +1,287 @@
+/*
+Copyright 2025 The Upbound Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/tidwall/gjson"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+const (
+	getResourceToolName        = "get_resource"
+	getResourceToolDescription = `
+Returns the JSON manifest of an observed composed resource. Identify the
+resource using the "resource" column of the table in the <composed> tag.
+Optionally supply paths (e.g. "status.conditions" or "spec.forProvider") to
+return only those fields instead of the whole manifest.
+`
+)
+
+// getResourceToolInputSchema is the JSON schema of the get_resource tool's
+// input.
+var getResourceToolInputSchema = anthropic.ToolInputSchemaParam{
+	Properties: map[string]any{
+		"resource": map[string]any{
+			"type":        "string",
+			"description": "The composed resource to get, from the \"resource\" column of the table in the <composed> tag.",
+		},
+		"paths": map[string]any{
+			"type":        "array",
+			"items":       map[string]any{"type": "string"},
+			"description": "Dot-separated paths of the fields to return, e.g. \"status.conditions\". Escape dots within a field name with a backslash. Returns the whole manifest if omitted.",
+		},
+	},
+	Required: []string{"resource"},
+	ExtraFields: map[string]any{
+		"additionalProperties": false,
+	},
+}
+
+// observedResources are the observed composed resources encoded as JSON,
+// keyed by composition resource name.
+type observedResources map[string]string
+
+// newObservedResources encodes the supplied observed composed resources as
+// JSON.
+func newObservedResources(in map[string]*fnv1.Resource) (observedResources, error) {
+	out := make(observedResources, len(in))
+	for name, r := range in {
+		j, err := marshaler.Marshal(r.GetResource())
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot marshal observed composed resource %q to JSON", name)
+		}
+		out[name] = string(j)
+	}
+	return out, nil
+}
+
+// names returns the sorted composition resource names of the resources.
+func (r observedResources) names() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Summary returns a compact table summarizing each resource's identity and
+// its Ready and Synced conditions.
+func (r observedResources) Summary() string {
+	if len(r) == 0 {
+		return "There are no composed resources."
+	}
+
+	b := &strings.Builder{}
+	b.WriteString("| resource | name | kind | apiVersion | Ready | Synced |\n")
+	b.WriteString("|---|---|---|---|---|---|\n")
+	for _, name := range r.names() {
+		j := r[name]
+		id := gjson.Get(j, "metadata.name").String()
+		if ns := gjson.Get(j, "metadata.namespace").String(); ns != "" {
+			id = ns + "/" + id
+		}
+		fmt.Fprintf(b, "| %s | %s | %s | %s | %s | %s |\n",
+			name, id,
+			gjson.Get(j, "kind").String(),
+			gjson.Get(j, "apiVersion").String(),
+			conditionSummary(j, "Ready"),
+			conditionSummary(j, "Synced"))
+	}
+	return b.String()
+}
+
+// Manifests returns the JSON manifest of each resource, wrapped in a tag that
+// names the resource.
+func (r observedResources) Manifests() string {
+	b := &strings.Builder{}
+	for _, name := range r.names() {
+		fmt.Fprintf(b, "<resource name=%q>\n%s\n</resource>\n", name, r[name])
+	}
+	return b.String()
+}
+
+// conditionSummary returns the status and reason of the supplied condition
+// type, e.g. "False (ReconcileError)", or "-" if the condition isn't set.
+func conditionSummary(resource, conditionType string) string {
+	c := gjson.Get(resource, fmt.Sprintf("status.conditions.#(type==%q)", conditionType))
+	if !c.Exists() {
+		return "-"
+	}
+	status := c.Get("status").String()
+	if reason := c.Get("reason").String(); reason != "" {
+		return fmt.Sprintf("%s (%s)", status, reason)
+	}
+	return status
+}
+
+// GetResource serves the get_resource tool. It returns the requested resource,
+// or only the requested paths of it. The returned error is intended to be sent
+// back to Claude so that it can correct its input.
+func (r observedResources) GetResource(input string) (string, error) {
+	name := gjson.Get(input, "resource").String()
+	j, ok := r[name]
+	if !ok {
+		return "", errors.Errorf("unknown resource %q, must be one of %s", name, strings.Join(r.names(), ", "))
+	}
+
+	paths := gjson.Get(input, "paths").Array()
+	if len(paths) == 0 {
+		return j, nil
+	}
+
+	fields := make(map[string]json.RawMessage, len(paths))
+	for _, p := range paths {
+		v := gjson.Get(j, p.String())
+		if !v.Exists() {
+			fields[p.String()] = json.RawMessage("null")
+			continue
+		}
+		fields[p.String()] = json.RawMessage(v.Raw)
+	}
+
+	// Marshalling a map of valid raw JSON can't fail.
+	out, _ := json.Marshal(fields)
+	return string(out), nil
+}
+
+const (
+	listEventsToolName        = "list_events"
+	listEventsToolDescription = `
+Returns the most recent Kubernetes events recorded for a composed resource, or
+for the composite resource if no resource is supplied. Events often contain
+errors reported by providers that never reach a resource's status.conditions.
+`
+
+	// maxEvents is the maximum number of events returned by the
+	// list_events tool.
+	maxEvents = 20
+
+	// eventInvolvedObjectUIDField indexes events by the UID of the object
+	// they involve.
+	eventInvolvedObjectUIDField = "involvedObject.uid"
+)
+
+// listEventsToolInputSchema is the JSON schema of the list_events tool's
+// input.
+var listEventsToolInputSchema = anthropic.ToolInputSchemaParam{
+	Properties: map[string]any{
+		"resource": map[string]any{
+			"type":        "string",
+			"description": "The composed resource to list events for, from the \"resource\" column of the table in the <composed> tag. Omit to list events for the composite resource.",
+		},
+	},
+	ExtraFields: map[string]any{
+		"additionalProperties": false,
+	},
+}
+
+// IndexEventByInvolvedObjectUID indexes events by the UID of the object they
+// involve. The list_events tool requires this index.
+func IndexEventByInvolvedObjectUID(o client.Object) []string {
+	e, ok := o.(*corev1.Event)
+	if !ok {
+		return nil
+	}
+	return []string{string(e.InvolvedObject.UID)}
+}
+
+// event is a compact representation of a Kubernetes event.
+type event struct {
+	Type     string    `json:"type"`
+	Reason   string    `json:"reason"`
+	Message  string    `json:"message"`
+	Count    int32     `json:"count,omitempty"`
+	LastSeen time.Time `json:"lastSeen"`
+	Source   string    `json:"source,omitempty"`
+}
+
+// An eventLister serves the list_events tool.
+type eventLister struct {
+	c client.Client
+
+	// composite is the observed composite resource, encoded as JSON.
+	composite string
+	observed  observedResources
+}
+
+// ListEvents serves the list_events tool. It returns the most recent events
+// involving the requested resource. The returned error is intended to be sent
+// back to Claude so that it can correct its input.
+func (l *eventLister) ListEvents(ctx context.Context, input string) (string, error) {
+	j := l.composite
+	if name := gjson.Get(input, "resource").String(); name != "" {
+		var ok bool
+		if j, ok = l.observed[name]; !ok {
+			return "", errors.Errorf("unknown resource %q, must be one of %s", name, strings.Join(l.observed.names(), ", "))
+		}
+	}
+
+	uid := gjson.Get(j, "metadata.uid").String()
+	if uid == "" {
+		return "", errors.New("the resource has no UID, so its events can't be listed")
+	}
+
+	el := &corev1.EventList{}
+	if err := l.c.List(ctx, el, client.MatchingFields{eventInvolvedObjectUIDField: uid}); err != nil {
+		return "", errors.Wrap(err, "cannot list events")
+	}
+
+	events := make([]event, 0, len(el.Items))
+	for _, e := range el.Items {
+		events = append(events, event{
+			Type:     e.Type,
+			Reason:   e.Reason,
+			Message:  e.Message,
+			Count:    e.Count,
+			LastSeen: lastSeen(e),
+			Source:   e.Source.Component,
+		})
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].LastSeen.After(events[j].LastSeen) })
+	if len(events) > maxEvents {
+		events = events[:maxEvents]
+	}
+
+	out, err := json.Marshal(events)
+	return string(out), errors.Wrap(err, "cannot marshal events to JSON")
+}
+
+// lastSeen returns the last time the supplied event was observed. Events
+// recorded using the events.k8s.io API may only set their event time.
+func lastSeen(e corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	default:
+		return e.CreationTimestamp.Time
+	}
+}
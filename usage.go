@@ -0,0 +1,120 @@
+/*
+Copyright 2025 The Upbound Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/crossplane/function-sdk-go/errors"
+	"github.com/crossplane/function-sdk-go/logging"
+
+	"github.com/upbound/function-claude-status-transformer/input/v1alpha1"
+)
+
+// recordUsageTimeout is the maximum amount of time recording token usage may
+// take. Usage is recorded even if the analysis timed out.
+const recordUsageTimeout = 10 * time.Second
+
+// Formats of the day and month usage is counted during.
+const (
+	usageDayFormat   = "2006-01-02"
+	usageMonthFormat = "2006-01"
+)
+
+// tokensUsed returns the number of tokens the supplied usage counts toward a
+// Budget.
+func tokensUsed(u anthropic.Usage) int64 {
+	return u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens + u.OutputTokens
+}
+
+// usageAt returns the supplied FunctionConfig's usage during the day and month
+// of the supplied time. Usage counted during an earlier day or month is reset.
+func usageAt(fc *v1alpha1.FunctionConfig, t time.Time) v1alpha1.Usage {
+	u := v1alpha1.Usage{Day: t.UTC().Format(usageDayFormat), Month: t.UTC().Format(usageMonthFormat)}
+	last := fc.Status.Usage
+	if last == nil {
+		return u
+	}
+	if last.Day == u.Day {
+		u.DailyTokens = last.DailyTokens
+	}
+	if last.Month == u.Month {
+		u.MonthlyTokens = last.MonthlyTokens
+	}
+	return u
+}
+
+// checkBudget returns an error if Claude has used the supplied FunctionConfig's
+// budget at the supplied time. Unrecorded tokens were used by the current
+// analysis, but aren't yet recorded in the FunctionConfig's status. It returns
+// nil if the FunctionConfig is nil or has no budget.
+func checkBudget(fc *v1alpha1.FunctionConfig, t time.Time, unrecorded int64) error {
+	if fc == nil || fc.Spec.Budget == nil {
+		return nil
+	}
+	b := fc.Spec.Budget
+	u := usageAt(fc, t)
+	if b.DailyTokens != nil && u.DailyTokens+unrecorded >= *b.DailyTokens {
+		return errors.Errorf("FunctionConfig %q has used its daily budget of %d tokens", fc.GetName(), *b.DailyTokens)
+	}
+	if b.MonthlyTokens != nil && u.MonthlyTokens+unrecorded >= *b.MonthlyTokens {
+		return errors.Errorf("FunctionConfig %q has used its monthly budget of %d tokens", fc.GetName(), *b.MonthlyTokens)
+	}
+	return nil
+}
+
+// recordUsage adds the supplied number of tokens to the usage recorded in the
+// supplied FunctionConfig's status, and logs the result. It does nothing if the
+// FunctionConfig is nil. Recording usage is best effort; errors are logged.
+func (f *Function) recordUsage(ctx context.Context, log logging.Logger, fc *v1alpha1.FunctionConfig, tokens int64) {
+	if fc == nil || tokens == 0 {
+		return
+	}
+
+	// The tokens were used whether or not the analysis was cancelled.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), recordUsageTimeout)
+	defer cancel()
+
+	name := fc.GetName()
+	u := v1alpha1.Usage{}
+
+	// Other calls to the Function, possibly in other replicas, may record
+	// usage concurrently. The FunctionConfig is read from the API server,
+	// since retrying with the client's cached copy could conflict again.
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &v1alpha1.FunctionConfig{}
+		if err := f.reader.Get(ctx, types.NamespacedName{Name: name}, latest); err != nil {
+			return err
+		}
+		u = usageAt(latest, f.now())
+		u.DailyTokens += tokens
+		u.MonthlyTokens += tokens
+		latest.Status.Usage = &u
+		return f.c.Status().Update(ctx, latest)
+	})
+	if err != nil {
+		log.Info("Cannot record token usage", "functionConfig", name, "tokens", tokens, "error", errors.Wrapf(err, "cannot update status of FunctionConfig %q", name))
+		return
+	}
+
+	log.Info("Recorded token usage", "functionConfig", name, "tokens", tokens, "day", u.Day, "dailyTokens", u.DailyTokens, "month", u.Month, "monthlyTokens", u.MonthlyTokens)
+}